@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"tinygo.org/x/bluetooth"
+)
+
+// Adapter is the subset of the bluetooth adapter used to discover and connect walking pads. It is implemented by
+// bluetoothAdapter for real hardware and by fakeAdapter, which simulates a walking pad.
+type Adapter interface {
+	Enable() error
+	Scan(callback func(device bluetooth.ScanResult)) error
+	StopScan() error
+	Connect(address bluetooth.Address) (PadConnection, error)
+	ConnectHeartRate(address bluetooth.Address) (HeartRateConnection, error)
+	SetConnectHandler(handler func(address bluetooth.Address, connected bool))
+}
+
+// PadConnection is an established connection to a walking pad, over which raw protocol frames are exchanged.
+type PadConnection interface {
+	// Write sends a command frame to the pad.
+	Write(buf []byte) error
+	// Notify registers the handler that receives all frames sent by the pad.
+	Notify(handler func(buf []byte)) error
+	// ReadModel returns the model string reported by the pad. It returns an empty string if the pad does not report
+	// its model.
+	ReadModel() (string, error)
+	// Service returns the UUID of the service that contains the characteristics of the walking pad protocol.
+	Service() bluetooth.UUID
+	Disconnect() error
+}
+
+// bluetoothAdapter implements Adapter using a real bluetooth adapter.
+type bluetoothAdapter struct {
+	adapter *bluetooth.Adapter
+}
+
+func newBluetoothAdapter(adapter *bluetooth.Adapter) *bluetoothAdapter {
+	return &bluetoothAdapter{adapter: adapter}
+}
+
+func (a *bluetoothAdapter) Enable() error {
+	return a.adapter.Enable()
+}
+
+func (a *bluetoothAdapter) Scan(callback func(device bluetooth.ScanResult)) error {
+	return a.adapter.Scan(func(_ *bluetooth.Adapter, device bluetooth.ScanResult) {
+		callback(device)
+	})
+}
+
+func (a *bluetoothAdapter) StopScan() error {
+	return a.adapter.StopScan()
+}
+
+func (a *bluetoothAdapter) SetConnectHandler(handler func(address bluetooth.Address, connected bool)) {
+	a.adapter.SetConnectHandler(func(device bluetooth.Device, connected bool) {
+		handler(device.Address, connected)
+	})
+}
+
+func (a *bluetoothAdapter) Connect(address bluetooth.Address) (PadConnection, error) {
+	device, err := a.adapter.Connect(address, bluetooth.ConnectionParams{})
+	if err != nil {
+		return nil, fmt.Errorf("connect: %w", err)
+	}
+
+	services, err := device.DiscoverServices(walkingPadUUIDs)
+	if err != nil {
+		return nil, fmt.Errorf("discover services: %w", err)
+	}
+
+	conn := &bluetoothConnection{device: device}
+	var rxFound, txFound bool
+	for _, service := range services {
+		characteristics, err := service.DiscoverCharacteristics(nil)
+		if err != nil {
+			return nil, fmt.Errorf("discover characteristics: %w", err)
+		}
+
+		for _, ch := range characteristics {
+			if strings.HasPrefix(ch.UUID().String(), "0000fe01") {
+				conn.rx = ch
+				conn.service = service.UUID()
+				rxFound = true
+			}
+			if strings.HasPrefix(ch.UUID().String(), "0000fe02") {
+				conn.tx = ch
+				conn.service = service.UUID()
+				txFound = true
+			}
+			if strings.HasPrefix(ch.UUID().String(), "00002a24") {
+				conn.model = ch
+				conn.modelFound = true
+			}
+		}
+	}
+
+	if !rxFound || !txFound {
+		return nil, fmt.Errorf("missing characteristics")
+	}
+
+	return conn, nil
+}
+
+type bluetoothConnection struct {
+	device     bluetooth.Device
+	rx         bluetooth.DeviceCharacteristic
+	tx         bluetooth.DeviceCharacteristic
+	model      bluetooth.DeviceCharacteristic
+	modelFound bool
+	service    bluetooth.UUID
+}
+
+func (conn *bluetoothConnection) Write(buf []byte) error {
+	_, err := conn.tx.WriteWithoutResponse(buf)
+	return err
+}
+
+func (conn *bluetoothConnection) Notify(handler func(buf []byte)) error {
+	return conn.rx.EnableNotifications(handler)
+}
+
+func (conn *bluetoothConnection) ReadModel() (string, error) {
+	if !conn.modelFound {
+		return "", nil
+	}
+	buf := make([]byte, 64)
+	n, err := conn.model.Read(buf)
+	if err != nil {
+		return "", fmt.Errorf("read model: %w", err)
+	}
+	return strings.TrimRight(string(buf[:n]), "\x00"), nil
+}
+
+func (conn *bluetoothConnection) Service() bluetooth.UUID {
+	return conn.service
+}
+
+func (conn *bluetoothConnection) Disconnect() error {
+	return conn.device.Disconnect()
+}
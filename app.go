@@ -2,16 +2,16 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"log/slog"
-	"net/http"
-	"net/url"
-	"os"
-	"path/filepath"
+	"math"
+	"math/rand/v2"
+	"net"
 	"reflect"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/getlantern/systray"
@@ -20,16 +20,24 @@ import (
 
 const (
 	GitHubURL = "https://github.com/tim-oster/walkingpad"
+
+	// stopFlushTimeout is how long stopping the belt may take before the pad is disconnected anyway.
+	stopFlushTimeout = 5 * time.Second
+
+	// stopDebounce is how long the belt has to stay stopped before a stop that was not triggered by the app counts.
+	stopDebounce = 2 * time.Second
+
+	// startBeltDelay is how long the pad counts down after a start command before the belt moves.
+	startBeltDelay = 2500 * time.Millisecond
 )
 
-type connectionState byte
+type StandbySpeedAction string
 
 const (
-	connectionStateDisconnected connectionState = iota
-	connectionStateScanning
-	connectionStateConnecting
-	connectionStateConnected
-	connectionStateReady
+	// StandbySpeedActionWake switches the pad into manual mode before changing the speed.
+	StandbySpeedActionWake StandbySpeedAction = "wake"
+	// StandbySpeedActionReject drops the speed change and logs a warning.
+	StandbySpeedActionReject StandbySpeedAction = "reject"
 )
 
 type speedItem struct {
@@ -37,99 +45,450 @@ type speedItem struct {
 	item  *systray.MenuItem
 }
 
-type App struct {
-	Adapter          *bluetooth.Adapter
-	PreferredDevice  string
-	TargetSpeed      float64
-	WebhookURL       *string
-	WebhookThreshold time.Duration
+// speedGroup is a submenu of speed items, which is used if there are too many speeds for a single menu.
+type speedGroup struct {
+	first, last float64
+	item        *systray.MenuItem
+}
+
+// maxSpeedItemsPerMenu is the number of speeds above which speed menus are grouped into submenus per km/h.
+const maxSpeedItemsPerMenu = 20
 
+type modeItem struct {
+	mode WalkingPadMode
+	item *systray.MenuItem
+}
+
+type App struct {
+	Adapter         Adapter
+	PreferredDevice string
+	// AutoSavePreferred saves the address of every connected device as the preferred device in the config file.
+	AutoSavePreferred bool
+	TargetSpeed       float64
+	WebhookURLs       []string
+	// SessionStartWebhookURLs are notified whenever the belt starts.
+	SessionStartWebhookURLs []string
+	// HeartbeatWebhookURLs receive the live stats every HeartbeatInterval while the belt is running.
+	HeartbeatWebhookURLs []string
+	HeartbeatInterval    time.Duration
+	WebhookMethod        string
+	WebhookHeaders       map[string]string
+	WebhookThreshold     time.Duration
+	WebhookMaxAttempts   int
+	FitExportDir         *string
+	HealthExportDir      *string
+
+	// StravaAccessToken is an OAuth access token with the activity:write scope. If set, every completed session is
+	// uploaded to Strava as a walk.
+	StravaAccessToken *string
+
+	// MovingSpeedThreshold is the speed below which the belt is considered stopped when detecting external changes.
+	MovingSpeedThreshold float64
+
+	HTTPListenAddr *string
+	HTTPRateLimit  float64
+	HTTPRateBurst  int
+	MetricsAddr    *string
+	ControlSocket  *string
+
+	// CompactTitle shows an icon instead of the title in the menu bar, which moves to the tooltip and the menu.
+	CompactTitle bool
+
+	// HeartRateDevice is the address of a heart rate monitor, whose measurements are recorded with the session.
+	HeartRateDevice *string
+
+	// StandbySpeedAction defines how speed changes are handled while the pad is in standby, as the pad ignores them.
+	StandbySpeedAction StandbySpeedAction
+
+	// SpeedStep is the difference between the speeds offered in the menus, in km/h.
+	SpeedStep float64
+	// MaxDeviceSpeed overrides the highest speed supported by the device, which otherwise depends on its model.
+	MaxDeviceSpeed *float64
+
+	// MaxSpeed caps the speed of the belt. It is written to the device on connect, and all speed changes are clamped
+	// to it.
+	MaxSpeed *float64
+
+	// Units is the unit system used to display distances and speeds.
+	Units Units
+
+	// BodyWeightKg is used to estimate the calories burned.
+	BodyWeightKg *float64
+
+	// NotifyStandby shows a desktop notification when the pad enters standby.
+	NotifyStandby bool
+	// NotifyPadErrors shows a desktop notification when the pad reports an unexpected belt state or a control code.
+	NotifyPadErrors bool
+
+	// SessionIdleSplit starts a new session if the belt was paused for longer than this. Zero never splits sessions.
+	SessionIdleSplit time.Duration
+
+	// StepGoal stops the belt once the session reaches the given number of steps.
+	StepGoal *int
+	// DurationGoal stops the belt once the session reaches the given walking time.
+	DurationGoal *time.Duration
+	// DistanceGoalKm stops the belt once the session reaches the given distance.
+	DistanceGoalKm *float64
+
+	Programs []IntervalProgram
+
+	// ReconnectBaseDelay is the delay after the first failed connection attempt. It doubles with every further failed
+	// attempt up to ReconnectMaxDelay.
+	ReconnectBaseDelay time.Duration
+	ReconnectMaxDelay  time.Duration
+
+	// ScanTimeout is how long to scan for walking pads, unless the preferred device is found earlier.
+	ScanTimeout time.Duration
+	// StaleTimeout is the time without status updates after which the pad is considered disconnected.
+	StaleTimeout time.Duration
+	// StartRamp accelerates the belt gradually to TargetSpeed when it is started.
+	StartRamp bool
+	// StopRamp slows the belt down gradually when it is paused or stopped.
+	StopRamp bool
+	// KeepRunningOnQuit leaves the belt running when the app is closed.
+	KeepRunningOnQuit bool
+	// AutoPauseIdle pauses the belt if it did not move for this long while the session is started. 0 disables it.
+	AutoPauseIdle time.Duration
+
+	// PadOptions configure how often the pad is polled and commands are sent.
+	PadOptions WalkingPadOptions
+
+	// mu guards everything that is changed after Init, e.g. the pad and the state. The main loop holds it while it
+	// processes a status, and the menus and the http handlers hold it while they handle a click or a request.
+	mu    sync.Mutex
 	pad   *WalkingPad
 	state state
 
-	mStartPause *systray.MenuItem
-	mStop       *systray.MenuItem
-	mSpeedItems []speedItem
+	subscribersMu sync.Mutex
+	subscribers   []chan Event
+
+	rampCancel      context.CancelFunc
+	goals           goalState
+	settings        settingsState
+	speedCoalescer  *coalescer
+	stream          *streamHub
+	controlListener net.Listener
+	heartRate       heartRateState
+	heartRateLost   chan struct{}
+	lifetime        lifetimeTotals
+	history         historyTotals
+	trayIcon        string
+	connectNow      chan struct{}
+	// actions are run by the main loop, see runInLoop
+	actions chan func()
+	// deliveries are the completed sessions that are handed off by deliverSessions
+	deliveries chan *sessionDelivery
+
+	mStartPause  *systray.MenuItem
+	mStop        *systray.MenuItem
+	mLock        *systray.MenuItem
+	mConnection  *systray.MenuItem
+	mDevices     *systray.MenuItem
+	mMode        *systray.MenuItem
+	mCooldown    *systray.MenuItem
+	mLifetime    *systray.MenuItem
+	mToday       *systray.MenuItem
+	mElapsed     *systray.MenuItem
+	mTitle       *systray.MenuItem
+	mWeek        *systray.MenuItem
+	mAverage     *systray.MenuItem
+	mPrograms    *systray.MenuItem
+	mSpeedItems  []speedItem
+	mSpeedGroups []speedGroup
+	mModeItems   []modeItem
+	mDeviceItems []*deviceItem
 }
 
 type state struct {
-	connState connectionState
-	started   bool
-	status    WalkingPadStatus
-
-	startedAt time.Time
+	conn    stateMachine
+	started bool
+	locked  bool
+	status  WalkingPadStatus
+
+	// lostWhileRunning is set if the connection was lost while the belt was running and could not be stopped.
+	lostWhileRunning bool
+	// disconnectedByUser pauses reconnecting until the user connects again.
+	disconnectedByUser bool
+	// chosenDevice is the address of the device picked by the user, which takes precedence over PreferredDevice.
+	chosenDevice string
+
+	startedAt     time.Time
+	pausedAt      time.Time
+	lastHeartbeat time.Time
+	// sessionStartedAt and totalStartedAt are when the session and the totals were first started, so that the elapsed
+	// time includes pauses, unlike the accumulated moving time.
+	sessionStartedAt, totalStartedAt time.Time
+	// stopSeenAt is set when the belt stopped on its own, until the stop is confirmed after stopDebounce.
+	stopSeenAt time.Time
+	// startingUntil is when the belt is expected to have reached its speed after startBelt, i.e. after the countdown
+	// of the pad and the start ramp. Until then, the belt does not count as idle.
+	startingUntil time.Time
+	// idleSince is set while the session is started but the belt is not moving.
+	idleSince time.Time
+	// sessionSavedAt is when the running session was last saved to disk.
+	sessionSavedAt time.Time
+	// resumable is the session saved by a previous run, which is resumed if the belt is still running on connect.
+	resumable *savedSession
 
 	timeAccum, timeAccumTotal   time.Duration
 	stepsAccum, stepsAccumTotal int
 	kmAccum, kmAccumTotal       float64
+	heartRate                   heartRateStats
+	// speedTime is the moving time of the session per speed in km/h, rounded to the precision of the protocol.
+	speedTime map[float64]time.Duration
+}
+
+// addSpeedTime adds the moving time to the time spent at the given speed.
+func (s *state) addSpeedTime(speed float64, d time.Duration) {
+	if d <= 0 || speed <= 0 {
+		return
+	}
+	if s.speedTime == nil {
+		s.speedTime = make(map[float64]time.Duration)
+	}
+	s.speedTime[math.Round(speed*10)/10] += d
+}
+
+// speedBreakdown returns the minutes spent at every speed, keyed by the speed in km/h with one decimal.
+func (s *state) speedBreakdown() map[string]float64 {
+	if len(s.speedTime) == 0 {
+		return nil
+	}
+	breakdown := make(map[string]float64, len(s.speedTime))
+	for speed, d := range s.speedTime {
+		breakdown[strconv.FormatFloat(speed, 'f', 1, 64)] = d.Minutes()
+	}
+	return breakdown
+}
+
+// statsDiff is the increase of the counters of the pad between two status updates.
+type statsDiff struct {
+	Time  time.Duration
+	Steps int
+	KM    float64
+}
+
+// accumulate adds the increase of the counters between prev and next to the session and total accumulators of the
+// state and returns it.
+//
+// The time counter of the pad only decreases if the pad reset its counters, e.g. because it was power-cycled. In that
+// case, next is treated as a fresh baseline and its counters are accumulated in full. Otherwise, if any other counter
+// decreased, nothing is accumulated and a zero diff is returned.
+func accumulate(prev, next WalkingPadStatus, s *state) statsDiff {
+	diff := statsDiff{
+		Time:  next.Time - prev.Time,
+		Steps: next.Steps - prev.Steps,
+		KM:    next.WalkedKM - prev.WalkedKM,
+	}
+	if diff.Time < 0 {
+		slog.Info("walking pad reset its counters", "prev", prev, "next", next)
+		diff = statsDiff{Time: next.Time, Steps: next.Steps, KM: next.WalkedKM}
+	} else if diff.Steps < 0 || diff.KM < 0 {
+		return statsDiff{}
+	}
+
+	s.timeAccum += diff.Time
+	s.stepsAccum += diff.Steps
+	s.kmAccum += diff.KM
+	s.timeAccumTotal += diff.Time
+	s.stepsAccumTotal += diff.Steps
+	s.kmAccumTotal += diff.KM
+	return diff
 }
 
 func (app *App) Init() {
+	lifetime, err := loadLifetimeTotals()
+	if err != nil {
+		slog.Error("loadLifetimeTotals", "err", err)
+	}
+	app.lifetime = lifetime
+
+	app.state.resumable, err = loadSavedSession()
+	if err != nil {
+		slog.Error("loadSavedSession", "err", err)
+	}
+
+	app.refreshHistoryTotals()
+
+	app.connectNow = make(chan struct{}, 1)
+	app.deliveries = make(chan *sessionDelivery, deliveryQueueSize)
+	go app.deliverSessions()
+	app.actions = make(chan func())
 	app.setupUI()
 	app.updateUI()
 
-	err := app.Adapter.Enable()
+	err = app.Adapter.Enable()
 	if err != nil {
 		panic(fmt.Sprintf("init bluetooth: %s", err))
 	}
 	app.Adapter.SetConnectHandler(app.onConnectionStateChange)
 
+	if app.HTTPListenAddr != nil {
+		go app.serveHTTP(*app.HTTPListenAddr)
+	}
+	if app.MetricsAddr != nil {
+		go app.serveMetrics(*app.MetricsAddr)
+	}
+	if app.ControlSocket != nil {
+		go app.serveControlSocket(*app.ControlSocket)
+	}
+	if app.HeartRateDevice != nil {
+		app.heartRateLost = make(chan struct{}, 1)
+		go app.connectHeartRateLoop(*app.HeartRateDevice)
+	}
+
+	var failedAttempts int
 	for {
-		if app.state.connState == connectionStateDisconnected {
+		app.mu.Lock()
+		if app.state.conn.Is(connectionStateDisconnected) && app.state.disconnectedByUser {
+			app.mu.Unlock()
+			app.waitForConnect(nil)
+			continue
+		}
+
+		if app.state.conn.Is(connectionStateDisconnected) {
 			err := app.attemptToConnect()
 			if err != nil {
 				slog.Error("attemptToConnect", "err", err)
+				app.fallBackToDisconnected()
 			}
-			if app.state.connState == connectionStateDisconnected {
+			if app.state.conn.Is(connectionStateDisconnected) {
 				// if still not connected, wait a bit before trying again
-				time.Sleep(5 * time.Second)
+				failedAttempts++
+				delay := app.reconnectDelay(failedAttempts)
+				slog.Info("retry connecting", "attempt", failedAttempts, "delay", delay)
+				app.mu.Unlock()
+				if app.waitForConnect(time.After(delay)) {
+					failedAttempts = 0
+				}
 				continue
 			}
+			failedAttempts = 0
 		}
 
-		if app.state.connState == connectionStateConnected && !app.pad.LastStatusTime.IsZero() {
-			app.state.connState = connectionStateReady
+		if app.state.conn.Is(connectionStateConnected) && !app.lastStatusTime().IsZero() {
+			err := app.transition(connectionStateReady)
+			if err != nil {
+				slog.Error("transition", "err", err)
+				app.disconnectConnectedPad()
+				app.mu.Unlock()
+				continue
+			}
+			app.state.lostWhileRunning = false
+			app.rebaseline()
 		}
 
-		if app.state.connState == connectionStateReady {
+		if app.state.conn.Is(connectionStateReady) && time.Since(app.lastStatusTime()) > app.StaleTimeout {
+			slog.Warn("walking pad stopped sending status updates, reconnecting", "last_status", app.lastStatusTime())
+			if app.state.started {
+				app.onConnectionLostWhileRunning()
+			}
+			app.disconnectConnectedPad()
+			app.mu.Unlock()
+			continue
+		}
+
+		if app.state.conn.Is(connectionStateReady) {
 			lastStatus := app.state.status
-			app.state.status = app.pad.LastStatus
+			app.state.status, _ = app.pad.LastStatus()
+			if app.state.status != lastStatus {
+				app.emit(StatsUpdatedEvent{Status: app.state.status})
+				app.publishStream()
+			}
+			if app.NotifyStandby && app.state.status.Mode == WalkingPadModeStandby && lastStatus.Mode != WalkingPadModeStandby {
+				err := notify("WalkingPad", "WalkingPad entered standby")
+				if err != nil {
+					slog.Error("notify", "err", err)
+				}
+			}
+			if app.state.status.BeltState != lastStatus.BeltState && !app.state.status.BeltState.Known() {
+				slog.Warn("walking pad reports unexpected belt state, it might need to be calibrated", "state", app.state.status.BeltState)
+				app.notifyPadError(fmt.Sprintf("WalkingPad reports belt state %d, it might need to be calibrated", app.state.status.BeltState))
+			}
+			if app.state.status.ControlCode != lastStatus.ControlCode && app.state.status.ControlCode != 0 {
+				slog.Warn("walking pad reports control code", "code", app.state.status.ControlCode)
+				app.emit(ControlCodeEvent{Code: app.state.status.ControlCode})
+				app.notifyPadError(fmt.Sprintf("WalkingPad reports code %d", app.state.status.ControlCode))
+			}
+
+			// the belt state reflects starts via the remote or the buttons of the pad right away, while the speed based
+			// detection below is a fallback for pads that do not report it reliably. Stops are detected by both in
+			// checkExternalStop.
+			if !app.state.started && !lastStatus.BeltState.Moving() && app.state.status.BeltState.Moving() {
+				slog.Info("belt started externally", "belt_state", app.state.status.BeltState)
+				app.onBeltStart()
+			}
 
 			// sync external changes
 			tempoDiff := app.state.status.Speed - lastStatus.Speed
-			if !app.state.started && tempoDiff > 0 {
+			if !app.state.started && tempoDiff > 0 && app.isMoving(app.state.status.Speed) {
 				app.onBeltStart()
 			}
-			if app.state.started && tempoDiff < 0 && app.state.status.Speed == 0 {
-				app.onBeltStop()
-			}
+			app.checkExternalStop(lastStatus)
+
+			app.checkIdle()
 
 			// increment difference to accumulate until stopped
 			if app.state.started {
-				timeDiff := app.state.status.Time - lastStatus.Time
-				stepsDiff := app.state.status.Steps - lastStatus.Steps
-				kmDiff := app.state.status.WalkedKM - lastStatus.WalkedKM
-				if timeDiff >= 0 && stepsDiff >= 0 && kmDiff >= 0 {
-					app.state.timeAccum += timeDiff
-					app.state.stepsAccum += stepsDiff
-					app.state.kmAccum += kmDiff
-					app.state.timeAccumTotal += timeDiff
-					app.state.stepsAccumTotal += stepsDiff
-					app.state.kmAccumTotal += kmDiff
+				diff := accumulate(lastStatus, app.state.status, &app.state)
+				app.lifetime.DurationMin += diff.Time.Minutes()
+				app.lifetime.Steps += diff.Steps
+				app.lifetime.DistanceKm += diff.KM
+				if bpm := app.heartRate.current(); bpm > 0 {
+					app.state.heartRate.add(bpm)
 				}
+				app.state.addSpeedTime(app.state.status.Speed, diff.Time)
+
+				app.sendHeartbeatWebhook()
+				app.saveSession()
 			}
+
+			app.checkGoals()
 		} else {
 			app.state.started = false
 			app.state.status = WalkingPadStatus{}
 		}
 
+		if time.Since(app.history.refreshedAt) >= historyRefreshInterval {
+			app.refreshHistoryTotals()
+		}
+
 		app.updateUI()
-		time.Sleep(500 * time.Millisecond)
+		app.mu.Unlock()
+		app.waitForConnect(time.After(500 * time.Millisecond))
+	}
+}
+
+// waitForConnect waits until the timeout passed or connectNow is signalled, and runs the actions that arrive meanwhile.
+// A nil timeout waits for connectNow only. It returns true if connectNow was signalled.
+func (app *App) waitForConnect(timeout <-chan time.Time) bool {
+	for {
+		select {
+		case <-timeout:
+			return false
+		case <-app.connectNow:
+			return true
+		case action := <-app.actions:
+			app.mu.Lock()
+			action()
+			app.mu.Unlock()
+		}
 	}
 }
 
+// runInLoop runs the action on the main loop, with mu held, once it waits for the next status. Everything that
+// disconnects the pad must go through it, as the main loop releases mu while connecting, and would otherwise keep
+// using the pad that was just disconnected.
+func (app *App) runInLoop(action func()) {
+	go func() { app.actions <- action }()
+}
+
 func (app *App) setupUI() {
-	systray.SetTitle("WP: connecting")
+	if app.CompactTitle {
+		app.mTitle = systray.AddMenuItem("", "")
+		app.mTitle.Disable()
+	}
+	app.updateTitle("WP: connecting")
 
 	app.mStartPause = systray.AddMenuItem("Start", "")
 	app.mStop = systray.AddMenuItem("Stop", "")
@@ -139,54 +498,44 @@ func (app *App) setupUI() {
 
 	go func() {
 		for {
+			// the lock is taken in each case, so that it is not held while waiting for a click
 			select {
 			case <-app.mStartPause.ClickedCh:
-				if !app.state.started {
-					app.onBeltStart()
-
-					if app.state.status.Mode == WalkingPadModeStandby {
-						app.pad.ChangeMode(WalkingPadModeManual)
-					}
-					app.pad.StartBelt()
-					app.pad.WaitCmd(2500 * time.Millisecond)
-					app.pad.ChangeSpeed(app.TargetSpeed)
-				} else {
-					app.pad.StopBelt()
-					app.onBeltStop()
+				app.mu.Lock()
+				err := app.toggleBelt()
+				if err != nil {
+					slog.Error("toggleBelt", "err", err)
 				}
 			case <-app.mStop.ClickedCh:
+				app.mu.Lock()
+				app.cancelRamp()
 				if app.state.started {
-					app.pad.StopBelt()
-					app.onBeltStop()
+					err := app.pauseBelt()
+					if err != nil {
+						slog.Error("pauseBelt", "err", err)
+						break
+					}
 				}
 
-				app.state.startedAt = time.Time{}
-				app.state.timeAccum = 0
-				app.state.stepsAccum = 0
-				app.state.kmAccum = 0
-				app.state.timeAccumTotal = 0
-				app.state.stepsAccumTotal = 0
-				app.state.kmAccumTotal = 0
+				app.resetSession()
 			}
 
 			app.updateUI()
+			app.mu.Unlock()
 		}
 	}()
 
-	selectedSpeed := 2.5
 	mSpeed := systray.AddMenuItem("Speed", "")
 	var (
 		speedClickCh []chan struct{}
 	)
-	for speed := 0.5; speed <= 6.0; speed += 0.5 {
-		item := mSpeed.AddSubMenuItem(fmt.Sprintf("%.1f km/h", speed), "")
-		if speed == selectedSpeed {
-			item.Check()
+	speeds := app.speedChoices()
+	app.mSpeedItems = app.addSpeedItems(mSpeed, speeds, false)
+	for _, si := range app.mSpeedItems {
+		if sameSpeed(si.speed, app.TargetSpeed) {
+			si.item.Check()
 		}
-		item.ClickedCh = make(chan struct{})
-
-		app.mSpeedItems = append(app.mSpeedItems, speedItem{speed: speed, item: item})
-		speedClickCh = append(speedClickCh, item.ClickedCh)
+		speedClickCh = append(speedClickCh, si.item.ClickedCh)
 	}
 	go func() {
 		var cases []reflect.SelectCase
@@ -199,17 +548,137 @@ func (app *App) setupUI() {
 		for {
 			chosen, _, ok := reflect.Select(cases)
 			if ok {
-				selectedSpeed = app.mSpeedItems[chosen].speed
-				app.TargetSpeed = selectedSpeed
-				app.updateUI()
+				app.mu.Lock()
+				app.selectSpeed(app.mSpeedItems[chosen].speed)
+				app.mu.Unlock()
+			}
+		}
+	}()
 
-				if app.state.connState == connectionStateReady && app.state.started {
-					app.pad.ChangeSpeed(selectedSpeed)
+	// like the buttons of the remote, these change the speed by one step
+	mFaster := systray.AddMenuItem("Faster", "")
+	mFaster.ClickedCh = make(chan struct{})
+	mSlower := systray.AddMenuItem("Slower", "")
+	mSlower.ClickedCh = make(chan struct{})
+	go func() {
+		for {
+			var faster bool
+			select {
+			case <-mFaster.ClickedCh:
+				faster = true
+			case <-mSlower.ClickedCh:
+			}
+
+			app.mu.Lock()
+			app.selectSpeed(app.adjacentSpeed(faster))
+			app.mu.Unlock()
+		}
+	}()
+
+	app.mCooldown = systray.AddMenuItem("Cool down & stop", "")
+	for _, minutes := range []int{1, 2, 5} {
+		item := app.mCooldown.AddSubMenuItem(fmt.Sprintf("%d min", minutes), "")
+		item.ClickedCh = make(chan struct{})
+		go func() {
+			for {
+				<-item.ClickedCh
+				app.mu.Lock()
+				app.cooldownAndStop(time.Duration(minutes) * time.Minute)
+				app.mu.Unlock()
+			}
+		}()
+	}
+
+	// the device does not report its lock state, so the last sent state is shown instead
+	app.mLock = systray.AddMenuItemCheckbox("Lock belt", "", false)
+	app.mLock.ClickedCh = make(chan struct{})
+	go func() {
+		for {
+			<-app.mLock.ClickedCh
+			app.mu.Lock()
+			pad, err := app.readyPad()
+			if err == nil {
+				err = pad.SetLock(!app.state.locked)
+			}
+			if err != nil {
+				slog.Error("SetLock", "err", err)
+			} else {
+				app.state.locked = !app.state.locked
+			}
+			app.updateUI()
+			app.mu.Unlock()
+		}
+	}()
+
+	// in auto mode, the pad adjusts the speed based on the position of the user on the belt
+	app.mMode = systray.AddMenuItem("Mode", "")
+	for _, m := range []struct {
+		mode  WalkingPadMode
+		label string
+	}{
+		{WalkingPadModeManual, "Manual"},
+		{WalkingPadModeAuto, "Auto"},
+	} {
+		mode := m.mode
+		item := app.mMode.AddSubMenuItemCheckbox(m.label, "", false)
+		item.ClickedCh = make(chan struct{})
+		app.mModeItems = append(app.mModeItems, modeItem{mode: mode, item: item})
+		go func() {
+			for {
+				<-item.ClickedCh
+				app.mu.Lock()
+				pad, err := app.readyPad()
+				if err == nil {
+					err = pad.ChangeMode(mode)
+				}
+				if err != nil {
+					slog.Error("ChangeMode", "err", err)
 				}
+				app.mu.Unlock()
 			}
+		}()
+	}
+
+	app.setupGoalsUI()
+	app.setupProgramsUI()
+
+	app.setupDevicesUI()
+	app.setupSettingsUI(speeds)
+
+	app.mConnection = systray.AddMenuItem("Connect now", "")
+	app.mConnection.ClickedCh = make(chan struct{})
+	go func() {
+		for {
+			<-app.mConnection.ClickedCh
+			app.runInLoop(func() {
+				if app.state.conn.Is(connectionStateDisconnected) {
+					app.state.disconnectedByUser = false
+					select {
+					case app.connectNow <- struct{}{}:
+					default:
+					}
+					return
+				}
+				app.disconnectByUser()
+			})
 		}
 	}()
 
+	app.mElapsed = systray.AddMenuItem("", "")
+	app.mElapsed.Disable()
+
+	app.mAverage = systray.AddMenuItem("", "")
+	app.mAverage.Disable()
+
+	app.mToday = systray.AddMenuItem("", "")
+	app.mToday.Disable()
+
+	app.mWeek = systray.AddMenuItem("", "")
+	app.mWeek.Disable()
+
+	app.mLifetime = systray.AddMenuItem("", "")
+	app.mLifetime.Disable()
+
 	mGitHub := systray.AddMenuItem("GitHub", "")
 	mGitHub.ClickedCh = make(chan struct{})
 	go func() {
@@ -231,24 +700,42 @@ func (app *App) setupUI() {
 }
 
 func (app *App) updateUI() {
-	switch app.state.connState {
+	var title string
+	switch app.state.conn.Current() {
 	case connectionStateDisconnected:
-		systray.SetTitle("WP: disconnected")
+		title = "WP: disconnected"
 	case connectionStateScanning:
-		systray.SetTitle("WP: scanning")
+		title = "WP: scanning"
 	case connectionStateConnecting:
-		systray.SetTitle("WP: connecting")
+		title = "WP: connecting"
 	case connectionStateConnected:
-		systray.SetTitle("WP: connected")
+		title = "WP: connected"
 	case connectionStateReady:
-		systray.SetTitle(fmt.Sprintf(
-			"WP: %s - %.2f km (~%d steps) @ [%.1f km/h]",
-			app.state.timeAccumTotal,
-			app.state.kmAccumTotal,
+		if !app.state.status.BeltState.Known() {
+			title = fmt.Sprintf("WP: belt error %d - calibrate belt", app.state.status.BeltState)
+			break
+		}
+		elapsed := app.state.timeAccumTotal.String()
+		if remaining, ok := app.remainingDuration(); ok {
+			elapsed = fmt.Sprintf("%s left", formatClock(remaining))
+		}
+		title = fmt.Sprintf(
+			"WP: %s - %.2f %s (~%d steps, ~%.0f kcal) @ [%.1f %s, %s]",
+			elapsed,
+			app.Units.Distance(app.state.kmAccumTotal),
+			app.Units.DistanceUnit(),
 			app.state.stepsAccumTotal,
-			app.state.status.Speed,
-		))
+			estimateCalories(app.state.kmAccumTotal, app.state.timeAccumTotal, app.bodyWeightKg()),
+			app.Units.Speed(app.state.status.Speed),
+			app.Units.SpeedUnit(),
+			app.state.status.Mode,
+		)
+	}
+
+	if app.state.lostWhileRunning {
+		title = "WP: connection lost while running - stop belt manually!"
 	}
+	app.updateTitle(title)
 
 	if !app.state.started {
 		app.mStartPause.SetTitle("Start")
@@ -261,14 +748,81 @@ func (app *App) updateUI() {
 		app.mStop.Enable()
 	}
 
-	if app.state.connState != connectionStateReady {
+	if !app.state.conn.Is(connectionStateReady) {
 		app.mStartPause.Disable()
 	} else {
 		app.mStartPause.Enable()
 	}
 
+	switch app.state.conn.Current() {
+	case connectionStateDisconnected:
+		app.mConnection.SetTitle("Connect now")
+		app.mConnection.Enable()
+	case connectionStateScanning, connectionStateConnecting:
+		app.mConnection.SetTitle("Connect now")
+		app.mConnection.Disable()
+	default:
+		app.mConnection.SetTitle("Disconnect")
+		app.mConnection.Enable()
+	}
+
+	app.updateDevicesUI()
+	app.updateSettingsUI()
+
+	app.mElapsed.SetTitle(app.elapsedTitle())
+	app.mAverage.SetTitle(app.averageTitle())
+
+	app.mToday.SetTitle(fmt.Sprintf("Today: %.1f %s", app.Units.Distance(app.history.TodayKm), app.Units.DistanceUnit()))
+	app.mWeek.SetTitle(fmt.Sprintf("This week: %.1f %s", app.Units.Distance(app.history.WeekKm), app.Units.DistanceUnit()))
+
+	app.mLifetime.SetTitle(fmt.Sprintf(
+		"Lifetime: %.1f %s / %d steps",
+		app.Units.Distance(app.lifetime.DistanceKm),
+		app.Units.DistanceUnit(),
+		app.lifetime.Steps,
+	))
+
+	if app.mPrograms != nil {
+		if !app.state.conn.Is(connectionStateReady) {
+			app.mPrograms.Disable()
+		} else {
+			app.mPrograms.Enable()
+		}
+	}
+
+	if !app.state.started {
+		app.mCooldown.Disable()
+	} else {
+		app.mCooldown.Enable()
+	}
+
+	if app.state.locked {
+		app.mLock.Check()
+	} else {
+		app.mLock.Uncheck()
+	}
+	if !app.state.conn.Is(connectionStateReady) {
+		app.mLock.Disable()
+	} else {
+		app.mLock.Enable()
+	}
+
+	if !app.state.conn.Is(connectionStateReady) {
+		app.mMode.Disable()
+	} else {
+		app.mMode.Enable()
+	}
+	for _, mi := range app.mModeItems {
+		if mi.mode == app.state.status.Mode {
+			mi.item.Check()
+			continue
+		}
+		mi.item.Uncheck()
+	}
+
+	// the selected speed is shown in any connection state, as it is used for the next start
 	for _, si := range app.mSpeedItems {
-		if si.speed == app.TargetSpeed {
+		if sameSpeed(si.speed, app.TargetSpeed) {
 			si.item.Check()
 			continue
 		}
@@ -276,195 +830,714 @@ func (app *App) updateUI() {
 	}
 }
 
-func (app *App) onConnectionStateChange(device bluetooth.Device, connected bool) {
-	if app.pad != nil && device.Address == app.pad.device.Address && !connected {
-		app.disconnectConnectedPad()
+func (app *App) notifyPadError(msg string) {
+	if !app.NotifyPadErrors {
+		return
+	}
+	err := notify("WalkingPad", msg)
+	if err != nil {
+		slog.Error("notify", "err", err)
+	}
+}
+
+// checkExternalStop stops the session once the belt stopped on its own or via the remote. Some pads briefly report no
+// speed or an idle belt while changing the speed, so a stop only counts if the belt stays stopped for stopDebounce.
+func (app *App) checkExternalStop(lastStatus WalkingPadStatus) {
+	if !app.state.started {
+		return
+	}
+
+	status := app.state.status
+	beltStopped := status.BeltState == WalkingPadBeltStateIdle || status.BeltState == WalkingPadBeltStateStandby
+	if app.state.stopSeenAt.IsZero() {
+		if lastStatus.BeltState == WalkingPadBeltStateRunning && beltStopped {
+			slog.Info("belt stopped externally", "belt_state", status.BeltState)
+			app.state.stopSeenAt = time.Now()
+		} else if status.Speed < lastStatus.Speed && !app.isMoving(status.Speed) {
+			app.state.stopSeenAt = time.Now()
+		}
+		return
+	}
+
+	if app.isMoving(status.Speed) && !beltStopped {
+		app.state.stopSeenAt = time.Time{}
+	} else if time.Since(app.state.stopSeenAt) >= stopDebounce {
+		app.onBeltStop()
+	}
+}
+
+// checkIdle pauses the belt if it did not move for AutoPauseIdle while the session is started, e.g. because the user
+// walked away without stopping it. A belt that is still starting does not count as idle, as it is slow or not moving
+// during the countdown of the pad and the start ramp.
+func (app *App) checkIdle() {
+	starting := time.Now().Before(app.state.startingUntil) || app.state.status.BeltState == WalkingPadBeltStateStarting
+	if app.AutoPauseIdle <= 0 || !app.state.started || starting || app.isMoving(app.state.status.Speed) {
+		app.state.idleSince = time.Time{}
+		return
+	}
+	if app.state.idleSince.IsZero() {
+		app.state.idleSince = time.Now()
+		return
+	}
+	if time.Since(app.state.idleSince) < app.AutoPauseIdle {
+		return
+	}
+
+	slog.Info("pause idle belt", "idle", time.Since(app.state.idleSince))
+	app.state.idleSince = time.Time{}
+	app.cancelRamp()
+	err := app.pauseBelt()
+	if err != nil {
+		slog.Error("pauseBelt", "err", err)
+	}
+}
+
+// elapsedSince returns the time since t including pauses, or 0 if t is not set.
+func elapsedSince(t time.Time) time.Duration {
+	if t.IsZero() {
+		return 0
+	}
+	return time.Since(t)
+}
+
+// elapsedTitle formats the elapsed time of the session including pauses, next to the time the belt was moving.
+func (app *App) elapsedTitle() string {
+	return fmt.Sprintf(
+		"Elapsed: %s, moving %s",
+		formatClock(elapsedSince(app.state.totalStartedAt)),
+		formatClock(app.state.timeAccumTotal),
+	)
+}
+
+// averageTitle formats the average speed and pace of the session. Both are unknown until the session has covered some
+// distance.
+func (app *App) averageTitle() string {
+	hours := app.state.timeAccumTotal.Hours()
+	distance := app.Units.Distance(app.state.kmAccumTotal)
+	if hours <= 0 || distance <= 0 {
+		return fmt.Sprintf("Average: - %s, pace - min/%s", app.Units.SpeedUnit(), app.Units.DistanceUnit())
+	}
+
+	pace := time.Duration(float64(app.state.timeAccumTotal) / distance)
+	return fmt.Sprintf(
+		"Average: %.1f %s, pace %s min/%s",
+		distance/hours,
+		app.Units.SpeedUnit(),
+		formatClock(pace),
+		app.Units.DistanceUnit(),
+	)
+}
+
+// lastStatusTime returns when the connected pad last reported its status.
+func (app *App) lastStatusTime() time.Time {
+	_, at := app.pad.LastStatus()
+	return at
+}
+
+// rebaseline uses the first status after (re)connecting as the baseline for accumulating, so that the counters the
+// pad reports for the time before the connection are not accumulated again. If the belt is still running, the
+// in-progress session is resumed, including one saved before the app was restarted.
+func (app *App) rebaseline() {
+	app.state.status, _ = app.pad.LastStatus()
+	if !app.isMoving(app.state.status.Speed) {
+		app.state.resumable = nil
+		return
+	}
+	if app.state.startedAt.IsZero() {
+		if app.resumeSavedSession() {
+			return
+		}
+		app.onBeltStart()
+		return
+	}
+	slog.Info("resume session after reconnect", "started_at", app.state.startedAt)
+	app.state.started = true
+}
+
+// reconnectDelay returns the delay after the given number of failed connection attempts. Up to 20% jitter is added, so
+// that multiple instances do not retry in lockstep.
+func (app *App) reconnectDelay(failedAttempts int) time.Duration {
+	delay := app.ReconnectBaseDelay
+	for i := 1; i < failedAttempts && delay < app.ReconnectMaxDelay; i++ {
+		delay *= 2
+	}
+	delay = min(delay, app.ReconnectMaxDelay)
+	return delay + time.Duration(rand.Float64()*0.2*float64(delay))
+}
+
+// transition moves the connection state machine into the given state. It fails if the transition is not allowed.
+func (app *App) transition(to connectionState) error {
+	return app.state.conn.Transition(to)
+}
+
+// fallBackToDisconnected moves the connection state machine into the disconnected state, which every state can fall
+// back to.
+func (app *App) fallBackToDisconnected() {
+	err := app.transition(connectionStateDisconnected)
+	if err != nil {
+		slog.Error("transition", "err", err)
+	}
+}
+
+// onConnectionStateChange is called by the bluetooth adapter, so the pad is disconnected by the main loop.
+func (app *App) onConnectionStateChange(address bluetooth.Address, connected bool) {
+	if app.HeartRateDevice != nil && strings.EqualFold(address.String(), *app.HeartRateDevice) && !connected {
+		app.onHeartRateDisconnected()
+		return
+	}
+	if connected {
+		return
+	}
+	app.runInLoop(func() {
+		if app.pad != nil && address == app.pad.address {
+			if app.state.started {
+				app.onConnectionLostWhileRunning()
+			}
+			app.disconnectConnectedPad()
+		}
+	})
+}
+
+// onConnectionLostWhileRunning makes a final attempt to stop the belt before control over it is lost, e.g. because
+// the bluetooth adapter was disabled. If that fails, the user is warned to stop the belt manually.
+func (app *App) onConnectionLostWhileRunning() {
+	slog.Warn("connection lost while belt is running, attempting to stop belt", "device", app.pad.address.String())
+
+	err := app.pad.EmergencyStop()
+	if err != nil {
+		slog.Error("failed to stop belt after connection loss, it must be stopped manually", "err", err)
+		app.state.lostWhileRunning = true
 	}
 }
 
 func (app *App) disconnectConnectedPad() {
 	if app.pad != nil {
-		slog.Info("disconnect walking pad", "device", app.pad.device.Address.String())
+		slog.Info("disconnect walking pad", "device", app.pad.address.String())
 
 		app.pad.Disconnect()
-		app.state.connState = connectionStateDisconnected
+		app.fallBackToDisconnected()
+		app.emit(DisconnectedEvent{Address: app.pad.address.String()})
 		app.pad = nil
 		app.updateUI()
 	}
 }
 
+// disconnectByUser stops the belt and disconnects the pad. The app does not reconnect until the user connects again.
+func (app *App) disconnectByUser() {
+	app.state.disconnectedByUser = true
+	app.stopAndDisconnect()
+}
+
+// savePreferredDevice remembers the address as the preferred device, so that the next launch connects to it directly.
+func (app *App) savePreferredDevice(address string) {
+	err := updateConfig(map[string]any{"preferredDevice": address})
+	if err != nil {
+		slog.Error("failed to save preferred device", "err", err)
+		return
+	}
+	slog.Info("saved preferred device", "device", address)
+	app.PreferredDevice = address
+}
+
+// stopAndDisconnect pauses the belt, if it is running, before disconnecting the pad.
+func (app *App) stopAndDisconnect() {
+	if app.state.started {
+		err := app.pauseBelt()
+		if err != nil {
+			slog.Error("pauseBelt", "err", err)
+		}
+	}
+	if app.pad != nil && !app.pad.Flush(stopFlushTimeout) {
+		slog.Warn("disconnect before all commands were sent", "timeout", stopFlushTimeout)
+	}
+	app.disconnectConnectedPad()
+}
+
+// attemptToConnect connects to the preferred or best found walking pad. It must be called with mu held, which it releases
+// while scanning and connecting, as both take a while. Meanwhile, the connection state tells the others that the pad
+// cannot be controlled.
 func (app *App) attemptToConnect() error {
 	if app.pad != nil {
 		app.disconnectConnectedPad()
 	}
 
-	slog.Info("start scan")
-	app.state.connState = connectionStateScanning
-	app.updateUI()
-
 	var preferredDevice *string
 	if app.PreferredDevice != "" {
-		preferredDevice = &app.PreferredDevice
+		device := app.PreferredDevice
+		preferredDevice = &device
+	}
+	if app.state.chosenDevice != "" {
+		device := app.state.chosenDevice
+		preferredDevice = &device
+	}
+
+	// a known pad, e.g. one that is paired already, can be connected to without scanning, which is a lot faster
+	if preferredDevice != nil {
+		if address, ok := parseAddress(*preferredDevice); ok {
+			slog.Info("connecting walking pad directly", "device", address.String())
+			err := app.transition(connectionStateConnecting)
+			if err != nil {
+				return err
+			}
+			app.updateUI()
+
+			candidate := WalkingPadCandidate{Device: bluetooth.ScanResult{Address: address}}
+			app.mu.Unlock()
+			pad, err := candidate.Connect(app.Adapter, app.PadOptions)
+			app.mu.Lock()
+			if err == nil {
+				return app.onPadConnected(pad)
+			}
+			slog.Info("failed to connect walking pad directly, scanning instead", "device", address.String(), "err", err)
+			app.fallBackToDisconnected()
+		}
 	}
-	devices, err := FindWalkingPadCandidates(app.Adapter, 5*time.Second, preferredDevice)
+
+	slog.Info("start scan")
+	err := app.transition(connectionStateScanning)
+	if err != nil {
+		return err
+	}
+	app.updateUI()
+
+	app.mu.Unlock()
+	devices, err := FindWalkingPadCandidates(app.Adapter, app.ScanTimeout, preferredDevice)
+	app.mu.Lock()
 	if err != nil {
 		return fmt.Errorf("find walking pad candidates: %w", err)
 	}
 
 	for _, device := range devices {
-		slog.Info("found walking pad", "device", device.Device.Address.String())
+		slog.Info("found walking pad", "device", device.Device.Address.String(), "service", device.MatchedService.String(), "rssi", device.Device.RSSI)
 	}
 
+	app.updateDeviceChoices(devices)
+
 	if len(devices) == 0 {
 		slog.Info("no walking pad found")
-		app.state.connState = connectionStateDisconnected
+		app.fallBackToDisconnected()
 		app.updateUI()
 		return nil
 	}
 
-	slog.Info("connecting walking pad", "device", devices[0].Device.Address.String())
-	app.state.connState = connectionStateConnecting
+	slog.Info("connecting walking pad", "device", devices[0].Device.Address.String(), "service", devices[0].MatchedService.String(), "rssi", devices[0].Device.RSSI)
+	err = app.transition(connectionStateConnecting)
+	if err != nil {
+		return err
+	}
 	app.updateUI()
 
-	pad, err := devices[0].Connect(app.Adapter, bluetooth.ConnectionParams{})
+	app.mu.Unlock()
+	pad, err := devices[0].Connect(app.Adapter, app.PadOptions)
+	app.mu.Lock()
 	if err != nil {
 		return fmt.Errorf("connect walking pad: %w", err)
 	}
+	return app.onPadConnected(pad)
+}
+
+func (app *App) onPadConnected(pad *WalkingPad) error {
+	err := app.transition(connectionStateConnected)
+	if err != nil {
+		pad.Disconnect()
+		return err
+	}
+	app.configurePad(pad)
 
-	slog.Info("connected to walking pad", "device", pad.device.Address.String())
-	app.state.connState = connectionStateConnected
+	slog.Info("connected to walking pad", "device", pad.address.String())
 	app.pad = pad
-	app.updateUI()
+	app.emit(ConnectedEvent{Address: pad.address.String()})
 
+	if app.AutoSavePreferred && app.PreferredDevice != pad.address.String() {
+		app.savePreferredDevice(pad.address.String())
+	}
+	app.updateUI()
 	return nil
 }
 
-func (app *App) onBeltStart() {
-	app.state.started = true
-	app.state.startedAt = time.Now()
+// configurePad applies the configured speed limits to a newly connected pad.
+func (app *App) configurePad(pad *WalkingPad) {
+	if app.MaxDeviceSpeed != nil {
+		pad.profile.MaxSpeed = *app.MaxDeviceSpeed
+	}
+	if app.MaxSpeed != nil {
+		err := pad.SetMaxSpeed(*app.MaxSpeed)
+		if err != nil {
+			slog.Error("failed to set max speed", "err", err)
+		}
+	}
 }
 
-func (app *App) onBeltStop() {
-	app.state.started = false
+// maxDeviceSpeed is the highest speed offered in the menus, as the pad might not be connected yet.
+func (app *App) maxDeviceSpeed() float64 {
+	if app.MaxDeviceSpeed != nil {
+		return *app.MaxDeviceSpeed
+	}
+	return defaultWalkingPadProfile.MaxSpeed
+}
 
-	sentWebhook, err := app.sendWebhook()
-	if err != nil {
-		slog.Error("sendWebhook", "err", err)
+// speedChoices returns the speeds offered in the menus, in steps of SpeedStep up to the max device speed. As the
+// protocol only supports multiples of 0.1 km/h, each speed is rounded to 0.1, e.g. steps of 0.25 yield 0.3, 0.5, 0.8.
+func (app *App) speedChoices() []float64 {
+	var speeds []float64
+	for i := 1; ; i++ {
+		// multiplying instead of adding avoids accumulating rounding errors
+		speed := math.Round(float64(i)*app.SpeedStep*10) / 10
+		if speed > app.maxDeviceSpeed() {
+			return speeds
+		}
+		speeds = append(speeds, speed)
 	}
+}
 
-	if sentWebhook {
-		// only reset if the webhook was sent - otherwise keep the data for the next attempt
-		app.state.startedAt = time.Time{}
-		app.state.timeAccum = 0
-		app.state.stepsAccum = 0
-		app.state.kmAccum = 0
+// adjacentSpeed returns the speed of the speed menu that follows the target speed in the given direction. At either
+// end of the menu, the first or last speed is returned.
+func (app *App) adjacentSpeed(faster bool) float64 {
+	speeds := app.speedChoices()
+	if len(speeds) == 0 {
+		return app.TargetSpeed
 	}
+	if faster {
+		for _, speed := range speeds {
+			if speed > app.TargetSpeed+0.01 {
+				return speed
+			}
+		}
+		return speeds[len(speeds)-1]
+	}
+	for i := len(speeds) - 1; i >= 0; i-- {
+		if speeds[i] < app.TargetSpeed-0.01 {
+			return speeds[i]
+		}
+	}
+	return speeds[0]
 }
 
-func (app *App) sendWebhook() (sent bool, err error) {
-	if app.WebhookURL == nil {
-		return false, nil
+// addSpeedItems adds an item for every speed to the menu. If there are more than maxSpeedItemsPerMenu speeds, they are
+// grouped into submenus per km/h, so that the menu stays manageable.
+func (app *App) addSpeedItems(menu *systray.MenuItem, speeds []float64, checkbox bool) []speedItem {
+	groups := [][]float64{speeds}
+	if len(speeds) > maxSpeedItemsPerMenu {
+		groups = nil
+		for _, speed := range speeds {
+			if len(groups) == 0 || math.Floor(speed) != math.Floor(groups[len(groups)-1][0]) {
+				groups = append(groups, nil)
+			}
+			groups[len(groups)-1] = append(groups[len(groups)-1], speed)
+		}
 	}
-	if time.Since(app.state.startedAt) < app.WebhookThreshold {
-		slog.Info("skip webhook: session length too short")
-		return false, nil
+
+	var items []speedItem
+	for _, group := range groups {
+		parent := menu
+		if len(groups) > 1 && len(group) > 1 {
+			first, last := group[0], group[len(group)-1]
+			parent = menu.AddSubMenuItem(app.speedGroupLabel(first, last), "")
+			app.mSpeedGroups = append(app.mSpeedGroups, speedGroup{first: first, last: last, item: parent})
+		}
+		for _, speed := range group {
+			var item *systray.MenuItem
+			if checkbox {
+				item = parent.AddSubMenuItemCheckbox(app.speedLabel(speed), "", false)
+			} else {
+				item = parent.AddSubMenuItem(app.speedLabel(speed), "")
+			}
+			item.ClickedCh = make(chan struct{})
+			items = append(items, speedItem{speed: speed, item: item})
+		}
 	}
+	return items
+}
 
-	reqURL := *app.WebhookURL
-	reqURL = strings.NewReplacer(
-		"{start_ts}", url.QueryEscape(app.state.startedAt.Format(time.RFC3339)),
-		"{duration_min}", url.QueryEscape(fmt.Sprintf("%.2f", app.state.timeAccum.Minutes())),
-		"{steps}", url.QueryEscape(fmt.Sprintf("%d", app.state.stepsAccum)),
-		"{distance_km}", url.QueryEscape(fmt.Sprintf("%.2f", app.state.kmAccum)),
-	).Replace(reqURL)
+// selectSpeed sets the target speed and sends it to the pad if the belt is running.
+func (app *App) selectSpeed(speed float64) {
+	app.setTargetSpeed(speed)
 
-	var statusCode int
-	defer func() {
-		var errStr string
+	if app.state.conn.Is(connectionStateReady) && app.state.started {
+		app.cancelRamp()
+		err := app.changeSpeed(app.TargetSpeed)
 		if err != nil {
-			errStr = err.Error()
+			slog.Error("changeSpeed", "err", err)
 		}
+	}
+}
+
+// setTargetSpeed sets the target speed, limited to the speeds of the speed menu.
+func (app *App) setTargetSpeed(speed float64) {
+	speed = math.Round(speed*10) / 10
+	speed = max(min(speed, app.maxDeviceSpeed()), math.Round(app.SpeedStep*10)/10)
+	app.TargetSpeed = app.clampSpeed(speed)
+	app.updateUI()
+}
+
+// sameSpeed reports whether both speeds are the same at the precision of the protocol, i.e. 0.1 km/h.
+func sameSpeed(a, b float64) bool {
+	return math.Abs(a-b) < 0.05
+}
+
+// clampSpeed limits the speed to MaxSpeed, if configured.
+func (app *App) clampSpeed(speed float64) float64 {
+	if app.MaxSpeed != nil && speed > *app.MaxSpeed {
+		return *app.MaxSpeed
+	}
+	return speed
+}
+
+var errPadNotReady = errors.New("walking pad is not ready")
 
-		line := webhookLogLine{
-			Timestamp:   time.Now(),
-			URL:         reqURL,
-			Status:      statusCode,
-			Err:         errStr,
-			StartAt:     app.state.startedAt,
-			DurationMin: app.state.timeAccum.Minutes(),
-			Steps:       app.state.stepsAccum,
-			DistanceKm:  app.state.kmAccum,
+// readyPad returns the connected pad if it can be controlled.
+func (app *App) readyPad() (*WalkingPad, error) {
+	pad := app.pad
+	if pad == nil || !app.state.conn.Is(connectionStateReady) {
+		return nil, errPadNotReady
+	}
+	return pad, nil
+}
+
+// changeSpeed sends the speed to the pad, taking care of speed changes while the pad is in standby.
+func (app *App) changeSpeed(speed float64) error {
+	pad, err := app.readyPad()
+	if err != nil {
+		return err
+	}
+
+	speed = app.clampSpeed(speed)
+	if app.state.status.Mode == WalkingPadModeStandby {
+		switch app.StandbySpeedAction {
+		case StandbySpeedActionReject:
+			slog.Warn("ignore speed change: walking pad is in standby", "speed", speed)
+			return nil
+		default:
+			slog.Info("wake walking pad from standby to change speed", "speed", speed)
+			err = pad.ChangeMode(WalkingPadModeManual)
+			if err != nil {
+				return err
+			}
 		}
-		err = logWebhook(line)
+	}
+	return pad.ChangeSpeed(speed)
+}
+
+// isMoving reports whether the given speed counts as a moving belt. Residual readings below MovingSpeedThreshold are
+// treated as sensor noise.
+func (app *App) isMoving(speed float64) bool {
+	return speed > 0 && speed >= app.MovingSpeedThreshold
+}
+
+// startBelt starts the belt and accelerates it to TargetSpeed.
+func (app *App) startBelt() error {
+	pad, err := app.readyPad()
+	if err != nil {
+		return err
+	}
+
+	if app.state.status.Mode == WalkingPadModeStandby {
+		err = pad.ChangeMode(WalkingPadModeManual)
 		if err != nil {
-			slog.Error("logWebhook", "err", err)
+			return err
 		}
-	}()
+	}
+	err = pad.StartBelt()
+	if err != nil {
+		return err
+	}
+	app.onBeltStart()
+
+	// the pad ignores speed changes while it counts down, so the queue waits until the belt moves. Speed changes made
+	// meanwhile are queued behind the initial speed and therefore take effect once the belt moves, too. The ramp is
+	// skipped if a program is started, as the program sets the speed itself.
+	err = pad.WaitCmd(startBeltDelay)
+	if err != nil {
+		return err
+	}
+	app.state.startingUntil = time.Now().Add(startBeltDelay + pad.opts.CommandDelay)
 
-	slog.Info("send webhook", "url", reqURL)
+	target := app.clampSpeed(app.TargetSpeed)
+	if app.StartRamp && app.rampCancel == nil && target > rampSpeedStep {
+		rampDuration := time.Duration(math.Ceil(target/rampSpeedStep)) * startRampInterval
+		app.state.startingUntil = app.state.startingUntil.Add(rampDuration)
+		err = pad.ChangeSpeed(rampSpeedStep)
+		if err != nil {
+			return err
+		}
+		app.startRamp(target)
+		return nil
+	}
+	return pad.ChangeSpeed(target)
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+// toggleBelt starts the belt if it is stopped and pauses it otherwise.
+func (app *App) toggleBelt() error {
+	app.cancelRamp()
+	if !app.state.started {
+		return app.startBelt()
+	}
+	return app.pauseBelt()
+}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
+// pauseBelt stops the belt without resetting the session stats.
+func (app *App) pauseBelt() error {
+	pad, err := app.readyPad()
 	if err != nil {
-		return false, fmt.Errorf("create request: %w", err)
+		return err
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	if app.StopRamp {
+		err = pad.StopBeltGradually(app.state.status.Speed, rampSpeedStep, stopRampStepDelay)
+	} else {
+		err = pad.StopBelt()
+	}
 	if err != nil {
-		return false, fmt.Errorf("send request: %w", err)
+		return err
 	}
-	statusCode = resp.StatusCode
+	app.onBeltStop()
+	return nil
+}
 
-	_, _ = io.Copy(io.Discard, resp.Body)
-	_ = resp.Body.Close()
+// resetSession discards all accumulated stats, so that the next start begins a fresh session.
+func (app *App) resetSession() {
+	app.state.startedAt = time.Time{}
+	app.state.sessionStartedAt = time.Time{}
+	app.state.totalStartedAt = time.Time{}
+	app.state.pausedAt = time.Time{}
+	app.state.timeAccum = 0
+	app.state.stepsAccum = 0
+	app.state.kmAccum = 0
+	app.state.timeAccumTotal = 0
+	app.state.stepsAccumTotal = 0
+	app.state.kmAccumTotal = 0
+	app.state.heartRate = heartRateStats{}
+	app.state.speedTime = nil
+	app.resetGoals()
+	app.removeSavedSession()
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return false, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+// sessionElapsed returns the time from the first start of the session until it was last paused, including pauses.
+func (app *App) sessionElapsed() time.Duration {
+	if app.state.sessionStartedAt.IsZero() {
+		return 0
 	}
+	return app.state.pausedAt.Sub(app.state.sessionStartedAt)
+}
 
-	return true, nil
+func (app *App) onBeltStart() {
+	if app.SessionIdleSplit > 0 && !app.state.pausedAt.IsZero() && time.Since(app.state.pausedAt) > app.SessionIdleSplit {
+		slog.Info("start new session: paused for too long", "paused", time.Since(app.state.pausedAt))
+		// the previous session is completed, even if it was carried over for being shorter than the threshold
+		app.completeSession()
+		app.resetSession()
+	}
+
+	app.state.started = true
+	app.state.startedAt = time.Now()
+	if app.state.sessionStartedAt.IsZero() {
+		app.state.sessionStartedAt = app.state.startedAt
+	}
+	if app.state.totalStartedAt.IsZero() {
+		app.state.totalStartedAt = app.state.startedAt
+	}
+	app.state.stopSeenAt = time.Time{}
+	app.state.lastHeartbeat = app.state.startedAt
+	app.emit(SessionStartedEvent{StartAt: app.state.startedAt})
+	app.sendSessionStartWebhook()
 }
 
-type webhookLogLine struct {
-	Timestamp   time.Time `json:"timestamp"`
-	URL         string    `json:"url"`
-	Status      int       `json:"status"`
-	Err         string    `json:"err,omitempty"`
-	StartAt     time.Time `json:"start_ts"`
-	DurationMin float64   `json:"duration_min"`
-	Steps       int       `json:"steps"`
-	DistanceKm  float64   `json:"distance_km"`
+func (app *App) onBeltStop() {
+	app.state.started = false
+	app.state.stopSeenAt = time.Time{}
+	app.state.pausedAt = time.Now()
+	app.emit(SessionEndedEvent{
+		StartAt:    app.state.startedAt,
+		Duration:   app.state.timeAccum,
+		Steps:      app.state.stepsAccum,
+		DistanceKm: app.state.kmAccum,
+	})
+
+	err := saveLifetimeTotals(app.lifetime)
+	if err != nil {
+		slog.Error("saveLifetimeTotals", "err", err)
+	}
+
+	// a session that is shorter than the threshold is carried over into the next one
+	if time.Since(app.state.startedAt) < app.WebhookThreshold {
+		slog.Info("continue session: session length too short")
+		app.queueDelivery(nil)
+		return
+	}
+	app.completeSession()
 }
 
-func logWebhook(line webhookLogLine) error {
-	logLine, err := json.Marshal(line)
+// completeSession appends the session to the session history, exports it, queues it for the webhooks and Strava, and
+// resets it. The session ends when the belt was last paused. It is recorded regardless of its delivery, and failed
+// deliveries are retried with the next stop.
+func (app *App) completeSession() {
+	if app.state.startedAt.IsZero() {
+		return
+	}
+
+	err := app.logSession()
 	if err != nil {
-		return fmt.Errorf("failed to marshal log line: %w", err)
+		slog.Error("logSession", "err", err)
 	}
+	app.refreshHistoryTotals()
 
-	configDir, err := os.UserConfigDir()
+	// the session is only exported once it is completed, as the stats of a paused session might still increase
+	err = app.exportFit()
+	if err != nil {
+		slog.Error("exportFit", "err", err)
+	}
+	err = app.exportHealth()
 	if err != nil {
-		return fmt.Errorf("failed to get user config dir: %w", err)
+		slog.Error("exportHealth", "err", err)
 	}
 
-	configPath := filepath.Join(configDir, "walkingpad_webhooks.jsonl")
+	delivery := app.newSessionDelivery()
+	app.queueDelivery(&delivery)
 
-	logFile, err := os.OpenFile(configPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
-	if err != nil {
-		return fmt.Errorf("failed to open log file: %w", err)
+	app.removeSavedSession()
+
+	app.state.startedAt = time.Time{}
+	app.state.sessionStartedAt = time.Time{}
+	app.state.timeAccum = 0
+	app.state.stepsAccum = 0
+	app.state.kmAccum = 0
+	app.state.heartRate = heartRateStats{}
+	app.state.speedTime = nil
+}
+
+// exportFit writes the current session as a FIT activity file into FitExportDir.
+func (app *App) exportFit() error {
+	if app.FitExportDir == nil || app.state.startedAt.IsZero() {
+		return nil
 	}
-	defer func() { _ = logFile.Close() }()
 
-	_, err = logFile.WriteString(string(logLine) + "\n")
+	filePath, err := writeFitFile(*app.FitExportDir, fitSession{
+		StartAt:    app.state.startedAt,
+		EndAt:      app.state.pausedAt,
+		Duration:   app.state.timeAccum,
+		DistanceKm: app.state.kmAccum,
+		Steps:      app.state.stepsAccum,
+	})
 	if err != nil {
-		return fmt.Errorf("failed to write to log file: %w", err)
+		return err
 	}
 
+	slog.Info("exported fit file", "path", filePath)
+
 	return nil
 }
 
+// Close stops the belt before disconnecting the pad, unless KeepRunningOnQuit is set.
 func (app *App) Close() {
-	app.disconnectConnectedPad()
+	app.closeControlSocket()
+
+	app.mu.Lock()
+	defer app.mu.Unlock()
+
+	if app.KeepRunningOnQuit {
+		app.disconnectConnectedPad()
+		return
+	}
+	app.cancelRamp()
+	app.stopAndDisconnect()
 }
@@ -0,0 +1,401 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"tinygo.org/x/bluetooth"
+)
+
+func TestSpeedChoices(t *testing.T) {
+	maxSpeed := 2.0
+
+	tests := []struct {
+		step float64
+		want []float64
+	}{
+		{step: 0.1, want: []float64{0.1, 0.2, 0.3, 0.4, 0.5, 0.6, 0.7, 0.8, 0.9, 1, 1.1, 1.2, 1.3, 1.4, 1.5, 1.6, 1.7, 1.8, 1.9, 2}},
+		{step: 0.25, want: []float64{0.3, 0.5, 0.8, 1, 1.3, 1.5, 1.8, 2}},
+		{step: 0.5, want: []float64{0.5, 1, 1.5, 2}},
+	}
+
+	for _, tt := range tests {
+		app := &App{SpeedStep: tt.step, MaxDeviceSpeed: &maxSpeed}
+		got := app.speedChoices()
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("step %v: expected %v, got %v", tt.step, tt.want, got)
+		}
+	}
+}
+
+func TestAdjacentSpeed(t *testing.T) {
+	maxSpeed := 2.0
+
+	tests := []struct {
+		name        string
+		step        float64
+		targetSpeed float64
+		faster      bool
+		want        float64
+	}{
+		{name: "faster by 0.25 rounds up", step: 0.25, targetSpeed: 0.5, faster: true, want: 0.8},
+		{name: "faster by 0.25 rounds down", step: 0.25, targetSpeed: 0.8, faster: true, want: 1},
+		{name: "slower by 0.25", step: 0.25, targetSpeed: 1.3, faster: false, want: 1},
+		{name: "faster from speed between steps", step: 0.25, targetSpeed: 1.1, faster: true, want: 1.3},
+		{name: "slower from speed between steps", step: 0.25, targetSpeed: 1.1, faster: false, want: 1},
+		{name: "faster at max speed", step: 0.5, targetSpeed: 2, faster: true, want: 2},
+		{name: "slower at min speed", step: 0.5, targetSpeed: 0.5, faster: false, want: 0.5},
+		{name: "faster by 0.1", step: 0.1, targetSpeed: 1.7, faster: true, want: 1.8},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := &App{SpeedStep: tt.step, MaxDeviceSpeed: &maxSpeed, TargetSpeed: tt.targetSpeed}
+			got := app.adjacentSpeed(tt.faster)
+			if got != tt.want {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestAccumulate(t *testing.T) {
+	tests := []struct {
+		name      string
+		prev      WalkingPadStatus
+		next      WalkingPadStatus
+		want      statsDiff
+		wantAccum statsDiff
+	}{
+		{
+			name:      "from zero",
+			next:      WalkingPadStatus{Time: 60 * time.Second, Steps: 100, WalkedKM: 0.5},
+			want:      statsDiff{Time: 60 * time.Second, Steps: 100, KM: 0.5},
+			wantAccum: statsDiff{Time: 160 * time.Second, Steps: 1100, KM: 2.5},
+		},
+		{
+			name:      "increase",
+			prev:      WalkingPadStatus{Time: 60 * time.Second, Steps: 100, WalkedKM: 0.5},
+			next:      WalkingPadStatus{Time: 75 * time.Second, Steps: 120, WalkedKM: 0.75},
+			want:      statsDiff{Time: 15 * time.Second, Steps: 20, KM: 0.25},
+			wantAccum: statsDiff{Time: 115 * time.Second, Steps: 1020, KM: 2.25},
+		},
+		{
+			name:      "unchanged",
+			prev:      WalkingPadStatus{Time: 60 * time.Second, Steps: 100, WalkedKM: 0.5},
+			next:      WalkingPadStatus{Time: 60 * time.Second, Steps: 100, WalkedKM: 0.5},
+			wantAccum: statsDiff{Time: 100 * time.Second, Steps: 1000, KM: 2},
+		},
+		{
+			name:      "steps decreased",
+			prev:      WalkingPadStatus{Time: 60 * time.Second, Steps: 100, WalkedKM: 0.5},
+			next:      WalkingPadStatus{Time: 65 * time.Second, Steps: 90, WalkedKM: 0.75},
+			wantAccum: statsDiff{Time: 100 * time.Second, Steps: 1000, KM: 2},
+		},
+		{
+			name:      "distance decreased",
+			prev:      WalkingPadStatus{Time: 60 * time.Second, Steps: 100, WalkedKM: 0.5},
+			next:      WalkingPadStatus{Time: 65 * time.Second, Steps: 110, WalkedKM: 0.25},
+			wantAccum: statsDiff{Time: 100 * time.Second, Steps: 1000, KM: 2},
+		},
+		{
+			name:      "counters reset",
+			prev:      WalkingPadStatus{Time: 60 * time.Second, Steps: 100, WalkedKM: 0.5},
+			next:      WalkingPadStatus{Time: 5 * time.Second, Steps: 10, WalkedKM: 0.25},
+			want:      statsDiff{Time: 5 * time.Second, Steps: 10, KM: 0.25},
+			wantAccum: statsDiff{Time: 105 * time.Second, Steps: 1010, KM: 2.25},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &state{
+				timeAccum:       100 * time.Second,
+				stepsAccum:      1000,
+				kmAccum:         2,
+				timeAccumTotal:  100 * time.Second,
+				stepsAccumTotal: 1000,
+				kmAccumTotal:    2,
+			}
+
+			got := accumulate(tt.prev, tt.next, s)
+			if got != tt.want {
+				t.Errorf("expected diff %+v, got %+v", tt.want, got)
+			}
+
+			session := statsDiff{Time: s.timeAccum, Steps: s.stepsAccum, KM: s.kmAccum}
+			if session != tt.wantAccum {
+				t.Errorf("expected session %+v, got %+v", tt.wantAccum, session)
+			}
+			total := statsDiff{Time: s.timeAccumTotal, Steps: s.stepsAccumTotal, KM: s.kmAccumTotal}
+			if total != tt.wantAccum {
+				t.Errorf("expected total %+v, got %+v", tt.wantAccum, total)
+			}
+		})
+	}
+}
+
+func TestAccumulateCounterResetMidSession(t *testing.T) {
+	// the pad is power-cycled after two minutes and restarts its counters from zero
+	statuses := []WalkingPadStatus{
+		{},
+		{Time: 60 * time.Second, Steps: 100, WalkedKM: 0.5},
+		{Time: 120 * time.Second, Steps: 200, WalkedKM: 1},
+		{Time: 5 * time.Second, Steps: 10, WalkedKM: 0.25},
+		{Time: 65 * time.Second, Steps: 110, WalkedKM: 0.5},
+	}
+
+	s := &state{}
+	for i := 1; i < len(statuses); i++ {
+		accumulate(statuses[i-1], statuses[i], s)
+	}
+
+	want := statsDiff{Time: 185 * time.Second, Steps: 310, KM: 1.5}
+	session := statsDiff{Time: s.timeAccum, Steps: s.stepsAccum, KM: s.kmAccum}
+	if session != want {
+		t.Errorf("expected session %+v, got %+v", want, session)
+	}
+	total := statsDiff{Time: s.timeAccumTotal, Steps: s.stepsAccumTotal, KM: s.kmAccumTotal}
+	if total != want {
+		t.Errorf("expected total %+v, got %+v", want, total)
+	}
+}
+
+// recordedFrame is a frame written to a recordingConnection.
+type recordedFrame struct {
+	buf []byte
+	at  time.Time
+}
+
+// recordingConnection records all frames written to it, except the stats requests.
+type recordingConnection struct {
+	fakeConnection
+
+	framesMu sync.Mutex
+	frames   []recordedFrame
+}
+
+func (conn *recordingConnection) Write(buf []byte) error {
+	if bytes.Equal(buf, []byte{247, 162, 0, 0, 162, 253}) {
+		return nil
+	}
+	conn.framesMu.Lock()
+	defer conn.framesMu.Unlock()
+	conn.frames = append(conn.frames, recordedFrame{buf: bytes.Clone(buf), at: time.Now()})
+	return nil
+}
+
+func (conn *recordingConnection) recorded() []recordedFrame {
+	conn.framesMu.Lock()
+	defer conn.framesMu.Unlock()
+	return slices.Clone(conn.frames)
+}
+
+// connectionAdapter connects to the given connection.
+type connectionAdapter struct {
+	fakeAdapter
+	conn PadConnection
+}
+
+func (a *connectionAdapter) Connect(bluetooth.Address) (PadConnection, error) {
+	return a.conn, nil
+}
+
+// newReadyTestApp returns an app that is connected to a pad over the given connection and ready to control it.
+func newReadyTestApp(t *testing.T, conn *recordingConnection) *App {
+	t.Helper()
+	configFile = filepath.Join(t.TempDir(), "walkingpad.json")
+
+	adapter := &connectionAdapter{conn: conn}
+	conn.adapter = &adapter.fakeAdapter
+	pad, err := WalkingPadCandidate{}.Connect(adapter, WalkingPadOptions{CommandDelay: minCommandDelay})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pad.Disconnect)
+
+	app := &App{TargetSpeed: 2.5, pad: pad}
+	for _, state := range []connectionState{connectionStateConnecting, connectionStateConnected, connectionStateReady} {
+		err := app.transition(state)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return app
+}
+
+func TestSpeedChangeWhileStarting(t *testing.T) {
+	conn := &recordingConnection{}
+	app := newReadyTestApp(t, conn)
+
+	err := app.startBelt()
+	if err != nil {
+		t.Fatal(err)
+	}
+	startedAt := time.Now()
+	// the speed is chosen right away, while the pad still counts down
+	err = app.changeSpeed(3.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !app.pad.Flush(2 * startBeltDelay) {
+		t.Fatal("timed out sending the commands")
+	}
+
+	frames := conn.recorded()
+	want := [][]byte{
+		{247, 162, 4, 1, 167, 253},  // start belt
+		{247, 162, 1, 25, 188, 253}, // target speed
+		{247, 162, 1, 35, 198, 253}, // chosen speed
+	}
+	if len(frames) != len(want) {
+		t.Fatalf("expected %d frames, got %v", len(want), frames)
+	}
+	for i, frame := range frames {
+		if !bytes.Equal(frame.buf, want[i]) {
+			t.Errorf("frame %d: expected %v, got %v", i, want[i], frame.buf)
+		}
+	}
+	// the pad ignores speed changes during the countdown, so they must only be written once the belt moves
+	for _, frame := range frames[1:] {
+		if elapsed := frame.at.Sub(startedAt); elapsed < startBeltDelay {
+			t.Errorf("speed %v written %v after the start, before the belt moves", frame.buf, elapsed)
+		}
+	}
+}
+
+func TestCheckIdleWhileStarting(t *testing.T) {
+	tests := []struct {
+		name          string
+		startingUntil time.Time
+		status        WalkingPadStatus
+		wantIdle      bool
+	}{
+		{name: "idle", wantIdle: true},
+		{name: "countdown or ramp", startingUntil: time.Now().Add(time.Minute)},
+		{name: "start finished", startingUntil: time.Now().Add(-time.Second), wantIdle: true},
+		{name: "pad reports starting", status: WalkingPadStatus{BeltState: WalkingPadBeltStateStarting}},
+		{name: "slow ramp speed", startingUntil: time.Now().Add(time.Minute), status: WalkingPadStatus{Speed: 0.5}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := &App{AutoPauseIdle: time.Minute, MovingSpeedThreshold: 1}
+			app.state.started = true
+			app.state.startingUntil = tt.startingUntil
+			app.state.status = tt.status
+
+			app.checkIdle()
+			if idle := !app.state.idleSince.IsZero(); idle != tt.wantIdle {
+				t.Errorf("expected idle: %v, got %v", tt.wantIdle, idle)
+			}
+		})
+	}
+}
+
+func TestRunInLoop(t *testing.T) {
+	app := &App{connectNow: make(chan struct{}, 1), actions: make(chan func())}
+
+	var ran bool
+	app.runInLoop(func() {
+		if app.mu.TryLock() {
+			t.Error("action runs without mu held")
+			app.mu.Unlock()
+		}
+		ran = true
+		app.connectNow <- struct{}{}
+	})
+
+	if !app.waitForConnect(nil) {
+		t.Fatal("waitForConnect returned without connectNow being signalled")
+	}
+	if !ran {
+		t.Error("action did not run")
+	}
+	if app.waitForConnect(time.After(10 * time.Millisecond)) {
+		t.Error("waitForConnect reported connectNow after the timeout")
+	}
+}
+
+func TestOnConnectionStateChangeOtherDevice(t *testing.T) {
+	conn := &recordingConnection{}
+	app := newReadyTestApp(t, conn)
+	app.connectNow = make(chan struct{}, 1)
+	app.actions = make(chan func())
+
+	// the disconnect of another device is only checked by the main loop, and must not touch the connected pad
+	app.onConnectionStateChange(testAddress(t, 9), false)
+	app.waitForConnect(time.After(100 * time.Millisecond))
+
+	if app.pad == nil || !app.state.conn.Is(connectionStateReady) {
+		t.Errorf("pad was disconnected, state %s", app.state.conn.Current())
+	}
+}
+
+func TestCheckExternalStop(t *testing.T) {
+	running := WalkingPadStatus{BeltState: WalkingPadBeltStateRunning, Speed: 3}
+	idle := WalkingPadStatus{BeltState: WalkingPadBeltStateIdle}
+
+	tests := []struct {
+		name     string
+		statuses []WalkingPadStatus
+		debounce bool
+		want     bool
+	}{
+		{
+			name:     "single idle frame while changing the speed",
+			statuses: []WalkingPadStatus{running, {BeltState: WalkingPadBeltStateIdle, Speed: 3}, running},
+			debounce: true,
+			want:     true,
+		},
+		{
+			name:     "single frame without speed",
+			statuses: []WalkingPadStatus{running, {BeltState: WalkingPadBeltStateRunning}, running},
+			debounce: true,
+			want:     true,
+		},
+		{
+			name:     "idle belt before the debounce passed",
+			statuses: []WalkingPadStatus{running, idle, idle},
+			want:     true,
+		},
+		{
+			name:     "idle belt after the debounce passed",
+			statuses: []WalkingPadStatus{running, idle, idle},
+			debounce: true,
+			want:     false,
+		},
+		{
+			name:     "standby belt that still reports its speed",
+			statuses: []WalkingPadStatus{running, {BeltState: WalkingPadBeltStateStandby, Speed: 3}, {BeltState: WalkingPadBeltStateStandby, Speed: 3}},
+			debounce: true,
+			want:     false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configFile = filepath.Join(t.TempDir(), "walkingpad.json")
+			app := &App{}
+			app.state.started = true
+			app.state.startedAt = time.Now()
+
+			for i := 1; i < len(tt.statuses); i++ {
+				app.state.status = tt.statuses[i]
+				app.checkExternalStop(tt.statuses[i-1])
+				if tt.debounce && !app.state.stopSeenAt.IsZero() {
+					app.state.stopSeenAt = app.state.stopSeenAt.Add(-stopDebounce)
+				}
+			}
+
+			if app.state.started != tt.want {
+				t.Errorf("started = %v, want %v", app.state.started, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"time"
+)
+
+// defaultBodyWeightKg is used to estimate calories if no body weight is configured.
+const defaultBodyWeightKg = 70.0
+
+// walkingMETs maps the upper bound of a walking speed in km/h to its metabolic equivalent, based on the Compendium of
+// Physical Activities. Faster speeds use the last entry.
+var walkingMETs = []struct {
+	maxSpeed float64
+	met      float64
+}{
+	{3.2, 2.0},
+	{4.0, 2.8},
+	{4.8, 3.0},
+	{5.6, 3.5},
+	{6.4, 4.3},
+	{0, 5.0},
+}
+
+// estimateCalories estimates the calories burned in kcal by walking the distance within the duration, using the MET
+// of the average speed: kcal = MET * weight in kg * hours.
+func estimateCalories(distanceKm float64, duration time.Duration, weightKg float64) float64 {
+	if duration <= 0 {
+		return 0
+	}
+
+	speed := distanceKm / duration.Hours()
+	met := walkingMETs[len(walkingMETs)-1].met
+	for _, entry := range walkingMETs[:len(walkingMETs)-1] {
+		if speed < entry.maxSpeed {
+			met = entry.met
+			break
+		}
+	}
+	return met * weightKg * duration.Hours()
+}
+
+// bodyWeightKg returns the configured body weight or defaultBodyWeightKg.
+func (app *App) bodyWeightKg() float64 {
+	if app.BodyWeightKg != nil {
+		return *app.BodyWeightKg
+	}
+	return defaultBodyWeightKg
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	cliStatusTimeout = 10 * time.Second
+
+	// cliStatusSchemaVersion is increased whenever fields of cliStatus are changed or removed in an incompatible way.
+	cliStatusSchemaVersion = 1
+)
+
+// cliStatus is the JSON output of the status command. Its schema is versioned, so that scripts can rely on it. Added
+// fields do not change the version.
+type cliStatus struct {
+	SchemaVersion   int     `json:"schema_version"`
+	ConnectionState string  `json:"connection_state"`
+	Device          string  `json:"device"`
+	Model           string  `json:"model,omitempty"`
+	BeltState       int     `json:"belt_state"`
+	Speed           float64 `json:"speed"`
+	Mode            string  `json:"mode"`
+	DurationMin     float64 `json:"duration_min"`
+	DistanceKm      float64 `json:"distance_km"`
+	Steps           int     `json:"steps"`
+	ControlCode     byte    `json:"control_code"`
+	// Session is the session the app saved last, which it resumes if the belt still runs once it connects. It is nil if
+	// there is none.
+	Session *cliSession `json:"session"`
+}
+
+// cliSession holds the accumulators of a session, which add up the counters of the pad across pauses and reconnects.
+type cliSession struct {
+	StartAt     time.Time `json:"start_ts"`
+	DurationMin float64   `json:"duration_min"`
+	DistanceKm  float64   `json:"distance_km"`
+	Steps       int       `json:"steps"`
+}
+
+// runCLI executes a subcommand without launching the systray. It connects to the walking pad, sends the command,
+// prints the resulting status, and disconnects. If ctx is cancelled, e.g. by Ctrl+C, the belt is stopped and the pad
+// disconnected before exiting.
+func (app *App) runCLI(ctx context.Context, args []string) error {
+	var (
+		run        func(pad *WalkingPad) error
+		jsonOutput bool
+		// flushTimeout is how long sending the commands may take
+		flushTimeout = cliStatusTimeout
+	)
+	switch args[0] {
+	case "start":
+		fs := flag.NewFlagSet("start", flag.ContinueOnError)
+		speed := fs.Float64("speed", app.TargetSpeed, "speed of the belt in km/h")
+		err := fs.Parse(args[1:])
+		if err != nil {
+			return err
+		}
+		run = func(pad *WalkingPad) error {
+			if status, _ := pad.LastStatus(); status.Mode == WalkingPadModeStandby {
+				err := pad.ChangeMode(WalkingPadModeManual)
+				if err != nil {
+					return err
+				}
+			}
+			err := pad.StartBelt()
+			if err != nil {
+				return err
+			}
+			err = pad.WaitCmd(startBeltDelay)
+			if err != nil {
+				return err
+			}
+			return pad.ChangeSpeed(min(app.clampSpeed(*speed), pad.profile.MaxSpeed))
+		}
+	case "stop":
+		fs := flag.NewFlagSet("stop", flag.ContinueOnError)
+		cooldownMin := fs.Float64("cooldown", 0, "lower the speed gradually over the given minutes before stopping")
+		err := fs.Parse(args[1:])
+		if err != nil {
+			return err
+		}
+		var cooldown time.Duration
+		if *cooldownMin != 0 {
+			cooldown, err = cooldownDuration(*cooldownMin)
+			if err != nil {
+				return err
+			}
+		}
+		flushTimeout += cooldown
+		run = func(pad *WalkingPad) error {
+			if cooldown == 0 {
+				return pad.StopBelt()
+			}
+			// like the cooldown of the app, the speed is lowered in steps of rampSpeedStep spread across the duration
+			status, _ := pad.LastStatus()
+			steps := max(int(math.Ceil(status.Speed/rampSpeedStep))-1, 1)
+			return pad.StopBeltGradually(status.Speed, rampSpeedStep, cooldown/time.Duration(steps))
+		}
+	case "status":
+		fs := flag.NewFlagSet("status", flag.ContinueOnError)
+		fs.BoolVar(&jsonOutput, "json", false, "print the status as a single JSON object")
+		err := fs.Parse(args[1:])
+		if err != nil {
+			return err
+		}
+		run = func(pad *WalkingPad) error { return nil }
+	case "scan":
+		return interruptible(ctx, app.scanCLI)
+	case "export":
+		fs := flag.NewFlagSet("export", flag.ContinueOnError)
+		csvPath := fs.String("csv", "", "path of the CSV file to write the session history to")
+		err := fs.Parse(args[1:])
+		if err != nil {
+			return err
+		}
+		if *csvPath == "" {
+			return errors.New("missing --csv path")
+		}
+		return exportSessionsCSV(*csvPath)
+	case "export-fit":
+		dir := "."
+		if app.FitExportDir != nil {
+			dir = *app.FitExportDir
+		}
+		fs := flag.NewFlagSet("export-fit", flag.ContinueOnError)
+		fs.StringVar(&dir, "dir", dir, "directory to write the FIT file to")
+		err := fs.Parse(args[1:])
+		if err != nil {
+			return err
+		}
+		if fs.NArg() != 1 {
+			return errors.New("usage: export-fit [--dir path] <session-id>")
+		}
+		id, err := strconv.Atoi(fs.Arg(0))
+		if err != nil {
+			return fmt.Errorf("invalid session id: %q", fs.Arg(0))
+		}
+		path, err := exportSessionFit(dir, id)
+		if err != nil {
+			return err
+		}
+		fmt.Println(path)
+		return nil
+	default:
+		return fmt.Errorf("unknown command %q: must be one of start, stop, status, scan, export, export-fit", args[0])
+	}
+
+	var pad *WalkingPad
+	err := interruptible(ctx, func() (err error) {
+		pad, err = app.connectCLI()
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	defer pad.Disconnect()
+
+	err = interruptible(ctx, func() error {
+		err := run(pad)
+		if err != nil {
+			return err
+		}
+		if !pad.Flush(flushTimeout) {
+			return errors.New("timed out sending the command to the walking pad")
+		}
+
+		// the stats are requested every few seconds, so the next status reflects the command
+		return waitForStatus(pad, time.Now())
+	})
+	if errors.Is(err, errInterrupted) {
+		app.interruptCLI(pad)
+	}
+	if err != nil {
+		return err
+	}
+
+	status, _ := pad.LastStatus()
+	if jsonOutput {
+		var session *cliSession
+		saved, err := loadSavedSession()
+		if err != nil {
+			return err
+		}
+		if saved != nil {
+			session = &cliSession{
+				StartAt:     saved.StartAt,
+				DurationMin: saved.TimeAccumTotal.Minutes(),
+				DistanceKm:  saved.DistanceKmTotal,
+				Steps:       saved.StepsTotal,
+			}
+		}
+
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(cliStatus{
+			SchemaVersion:   cliStatusSchemaVersion,
+			ConnectionState: app.state.conn.Current().String(),
+			Device:          pad.address.String(),
+			Model:           pad.Model,
+			BeltState:       int(status.BeltState),
+			Speed:           status.Speed,
+			Mode:            status.Mode.String(),
+			DurationMin:     status.Time.Minutes(),
+			DistanceKm:      status.WalkedKM,
+			Steps:           status.Steps,
+			ControlCode:     status.ControlCode,
+			Session:         session,
+		})
+	}
+
+	fmt.Printf("device:   %s\n", pad.address.String())
+	fmt.Printf("mode:     %s\n", status.Mode)
+	fmt.Printf("speed:    %.1f %s\n", app.Units.Speed(status.Speed), app.Units.SpeedUnit())
+	fmt.Printf("time:     %s\n", status.Time)
+	fmt.Printf("distance: %.2f %s\n", app.Units.Distance(status.WalkedKM), app.Units.DistanceUnit())
+	fmt.Printf("steps:    %d\n", status.Steps)
+	return nil
+}
+
+var errInterrupted = errors.New("interrupted")
+
+// interruptible runs fn in the background and returns its result, or errInterrupted if ctx is cancelled first.
+func interruptible(ctx context.Context, fn func() error) error {
+	done := make(chan error, 1)
+	go func() { done <- fn() }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return errInterrupted
+	}
+}
+
+// interruptCLI leaves the pad in a known state if a command is interrupted, i.e. stops the belt like quitting the app
+// does, and disconnects.
+func (app *App) interruptCLI(pad *WalkingPad) {
+	if !app.KeepRunningOnQuit {
+		err := pad.StopBelt()
+		if err == nil && !pad.Flush(stopFlushTimeout) {
+			err = errors.New("timed out")
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to stop the belt: %s\n", err)
+		}
+	}
+	pad.Disconnect()
+}
+
+// scanCLI lists all walking pads that can be discovered, so that one of them can be configured as the preferred device.
+func (app *App) scanCLI() error {
+	err := app.Adapter.Enable()
+	if err != nil {
+		return fmt.Errorf("init bluetooth: %w", err)
+	}
+
+	fmt.Println("scanning for walking pads...")
+	devices, err := FindWalkingPadCandidates(app.Adapter, app.ScanTimeout, nil)
+	if err != nil {
+		return fmt.Errorf("find walking pad candidates: %w", err)
+	}
+	if len(devices) == 0 {
+		return errors.New("no walking pad found")
+	}
+
+	for _, device := range devices {
+		fmt.Printf("%s\tname=%q\trssi=%d\n", device.Device.Address.String(), device.Device.LocalName(), device.Device.RSSI)
+	}
+	return nil
+}
+
+// connectCLI connects to the preferred or first found walking pad and waits for its first status. Like the main loop,
+// it moves the connection state machine along, which falls back to disconnected if connecting fails.
+func (app *App) connectCLI() (*WalkingPad, error) {
+	pad, err := app.tryConnectCLI()
+	if err != nil {
+		app.fallBackToDisconnected()
+		return nil, err
+	}
+	return pad, nil
+}
+
+func (app *App) tryConnectCLI() (*WalkingPad, error) {
+	err := app.Adapter.Enable()
+	if err != nil {
+		return nil, fmt.Errorf("init bluetooth: %w", err)
+	}
+
+	var preferredDevice *string
+	if app.PreferredDevice != "" {
+		preferredDevice = &app.PreferredDevice
+	}
+	err = app.transition(connectionStateScanning)
+	if err != nil {
+		return nil, err
+	}
+	devices, err := FindWalkingPadCandidates(app.Adapter, app.ScanTimeout, preferredDevice)
+	if err != nil {
+		return nil, fmt.Errorf("find walking pad candidates: %w", err)
+	}
+	if len(devices) == 0 {
+		return nil, errors.New("no walking pad found")
+	}
+
+	err = app.transition(connectionStateConnecting)
+	if err != nil {
+		return nil, err
+	}
+	pad, err := devices[0].Connect(app.Adapter, app.PadOptions)
+	if err != nil {
+		return nil, fmt.Errorf("connect walking pad: %w", err)
+	}
+	err = app.transition(connectionStateConnected)
+	if err != nil {
+		pad.Disconnect()
+		return nil, err
+	}
+	app.configurePad(pad)
+
+	err = waitForStatus(pad, time.Time{})
+	if err == nil {
+		err = app.transition(connectionStateReady)
+	}
+	if err != nil {
+		pad.Disconnect()
+		return nil, err
+	}
+	return pad, nil
+}
+
+// waitForStatus waits until the pad reported a status after the given time.
+func waitForStatus(pad *WalkingPad, after time.Time) error {
+	deadline := time.Now().Add(cliStatusTimeout)
+	for {
+		if _, at := pad.LastStatus(); at.After(after) {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return errors.New("timeout waiting for walking pad status")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
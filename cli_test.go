@@ -0,0 +1,36 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestConnectCLIConnectionState(t *testing.T) {
+	configFile = filepath.Join(t.TempDir(), "walkingpad.json")
+
+	t.Run("connected", func(t *testing.T) {
+		app := &App{Adapter: newFakeAdapter(nil), ScanTimeout: 100 * time.Millisecond}
+		pad, err := app.connectCLI()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer pad.Disconnect()
+
+		if !app.state.conn.Is(connectionStateReady) {
+			t.Errorf("connection state = %s, want %s", app.state.conn.Current(), connectionStateReady)
+		}
+	})
+
+	t.Run("no walking pad found", func(t *testing.T) {
+		app := &App{Adapter: &scriptedAdapter{}, ScanTimeout: 100 * time.Millisecond}
+		_, err := app.connectCLI()
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+
+		if !app.state.conn.Is(connectionStateDisconnected) {
+			t.Errorf("connection state = %s, want %s", app.state.conn.Current(), connectionStateDisconnected)
+		}
+	})
+}
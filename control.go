@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// serveControlSocket accepts line-delimited commands on a Unix socket until the listener is closed. Every command is
+// answered with a single JSON line, which is either the status as returned by GET /status, or an error object.
+//
+// Supported commands: start, stop, toggle, speed <km/h>, status
+func (app *App) serveControlSocket(path string) {
+	// a socket left behind by a crashed instance would make listening fail
+	if info, err := os.Lstat(path); err == nil && info.Mode()&fs.ModeSocket != 0 {
+		_ = os.Remove(path)
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		slog.Error("serveControlSocket", "err", err)
+		return
+	}
+	app.mu.Lock()
+	app.controlListener = listener
+	app.mu.Unlock()
+
+	slog.Info("start control socket", "path", path)
+
+	for {
+		conn, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			slog.Error("accept control connection", "err", err)
+			continue
+		}
+		go app.handleControlConn(conn)
+	}
+}
+
+// closeControlSocket stops accepting commands. Closing the listener also removes the socket file.
+func (app *App) closeControlSocket() {
+	app.mu.Lock()
+	listener := app.controlListener
+	app.mu.Unlock()
+
+	if listener != nil {
+		_ = listener.Close()
+	}
+}
+
+func (app *App) handleControlConn(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+
+	enc := json.NewEncoder(conn)
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		// commands run one at a time, like the requests of the HTTP API
+		var resp any
+		app.mu.Lock()
+		err := app.runControlCommand(strings.Fields(line))
+		if err != nil {
+			resp = map[string]string{"error": err.Error()}
+		} else {
+			resp = app.statusResponse()
+		}
+		app.mu.Unlock()
+
+		err = enc.Encode(resp)
+		if err != nil {
+			return
+		}
+	}
+}
+
+// runControlCommand executes a single command, like the corresponding endpoint of the HTTP API.
+func (app *App) runControlCommand(args []string) error {
+	switch args[0] {
+	case "status":
+		return nil
+	case "start":
+		if _, err := app.readyPad(); err != nil {
+			return err
+		}
+		if app.state.started {
+			return nil
+		}
+		app.cancelRamp()
+		err := app.startBelt()
+		app.updateUI()
+		return err
+	case "stop":
+		if _, err := app.readyPad(); err != nil {
+			return err
+		}
+		if !app.state.started {
+			return nil
+		}
+		app.cancelRamp()
+		err := app.pauseBelt()
+		app.updateUI()
+		return err
+	case "toggle":
+		if _, err := app.readyPad(); err != nil {
+			return err
+		}
+		err := app.toggleBelt()
+		app.updateUI()
+		return err
+	case "speed":
+		pad, err := app.readyPad()
+		if err != nil {
+			return err
+		}
+		if len(args) != 2 {
+			return errors.New("usage: speed <km/h>")
+		}
+		speed, err := strconv.ParseFloat(args[1], 64)
+		if err != nil || speed <= 0 || speed > pad.profile.MaxSpeed {
+			return fmt.Errorf("invalid speed: %q", args[1])
+		}
+
+		app.selectSpeed(speed)
+		return nil
+	default:
+		return fmt.Errorf("unknown command %q: must be one of start, stop, toggle, speed, status", args[0])
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/getlantern/systray"
+)
+
+// maxDeviceItems is the number of devices that can be listed in the device picker, as menu items cannot be removed.
+const maxDeviceItems = 5
+
+type deviceItem struct {
+	address string
+	item    *systray.MenuItem
+}
+
+// setupDevicesUI adds the device picker, which lists the found walking pads if more than one was found.
+func (app *App) setupDevicesUI() {
+	app.mDevices = systray.AddMenuItem("Devices", "")
+	app.mDevices.Hide()
+
+	for i := 0; i < maxDeviceItems; i++ {
+		item := app.mDevices.AddSubMenuItemCheckbox("", "", false)
+		item.Hide()
+		item.ClickedCh = make(chan struct{})
+		app.mDeviceItems = append(app.mDeviceItems, &deviceItem{item: item})
+
+		go func() {
+			for {
+				<-item.ClickedCh
+				app.runInLoop(func() {
+					app.chooseDevice(app.mDeviceItems[i].address)
+				})
+			}
+		}()
+	}
+}
+
+// updateDeviceChoices lists the found walking pads in the device picker. The picker is only updated if there is
+// something to choose from.
+func (app *App) updateDeviceChoices(devices []WalkingPadCandidate) {
+	if len(devices) < 2 {
+		return
+	}
+
+	for i, di := range app.mDeviceItems {
+		if i >= len(devices) {
+			di.address = ""
+			di.item.Hide()
+			continue
+		}
+
+		device := devices[i].Device
+		di.address = device.Address.String()
+		title := di.address
+		if name := device.LocalName(); name != "" {
+			title = fmt.Sprintf("%s (%s)", name, di.address)
+		}
+		di.item.SetTitle(title)
+		di.item.Show()
+	}
+	app.mDevices.Show()
+}
+
+// chooseDevice connects to the given device instead of the current one. The choice is kept until the app is closed.
+func (app *App) chooseDevice(address string) {
+	if address == "" || (app.pad != nil && app.pad.address.String() == address) {
+		return
+	}
+
+	slog.Info("switch walking pad", "device", address)
+	app.state.chosenDevice = address
+	if app.pad != nil {
+		app.stopAndDisconnect()
+	}
+
+	app.state.disconnectedByUser = false
+	select {
+	case app.connectNow <- struct{}{}:
+	default:
+	}
+}
+
+func (app *App) updateDevicesUI() {
+	for _, di := range app.mDeviceItems {
+		if app.pad != nil && di.address == app.pad.address.String() {
+			di.item.Check()
+			continue
+		}
+		di.item.Uncheck()
+	}
+}
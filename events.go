@@ -0,0 +1,72 @@
+package main
+
+import (
+	"time"
+)
+
+// Event is emitted by the App whenever the connection or session changes. Consume them via App.Subscribe.
+type Event interface {
+	isEvent()
+}
+
+type ConnectedEvent struct {
+	Address string
+}
+
+type DisconnectedEvent struct {
+	Address string
+}
+
+type StatsUpdatedEvent struct {
+	Status WalkingPadStatus
+}
+
+type SessionStartedEvent struct {
+	StartAt time.Time
+}
+
+type SessionEndedEvent struct {
+	StartAt    time.Time
+	Duration   time.Duration
+	Steps      int
+	DistanceKm float64
+}
+
+// ControlCodeEvent is emitted whenever the pad reports a new control code, e.g. because a button of the remote was
+// pressed. The codes are not documented, see WalkingPadStatus.ControlCode.
+type ControlCodeEvent struct {
+	Code byte
+}
+
+func (ConnectedEvent) isEvent()      {}
+func (DisconnectedEvent) isEvent()   {}
+func (StatsUpdatedEvent) isEvent()   {}
+func (SessionStartedEvent) isEvent() {}
+func (SessionEndedEvent) isEvent()   {}
+func (ControlCodeEvent) isEvent()    {}
+
+const eventBufferSize = 100
+
+// Subscribe returns a new channel of all events emitted by the app, so that every consumer receives every event. It
+// must be called before Init. Events are dropped if the channel is not drained fast enough, so a slow consumer never
+// blocks the app or the other consumers.
+func (app *App) Subscribe() <-chan Event {
+	app.subscribersMu.Lock()
+	defer app.subscribersMu.Unlock()
+
+	events := make(chan Event, eventBufferSize)
+	app.subscribers = append(app.subscribers, events)
+	return events
+}
+
+func (app *App) emit(event Event) {
+	app.subscribersMu.Lock()
+	defer app.subscribersMu.Unlock()
+
+	for _, events := range app.subscribers {
+		select {
+		case events <- event:
+		default:
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import "testing"
+
+func TestSubscribe(t *testing.T) {
+	app := &App{}
+	first := app.Subscribe()
+	second := app.Subscribe()
+
+	app.emit(ConnectedEvent{Address: "pad"})
+
+	for i, events := range []<-chan Event{first, second} {
+		select {
+		case event := <-events:
+			if event != (ConnectedEvent{Address: "pad"}) {
+				t.Errorf("subscriber %d: unexpected event %#v", i, event)
+			}
+		default:
+			t.Errorf("subscriber %d: expected an event", i)
+		}
+	}
+}
+
+func TestSubscribeSlowConsumer(t *testing.T) {
+	app := &App{}
+	slow := app.Subscribe()
+	fast := app.Subscribe()
+
+	// the slow subscriber never drains its channel, which must neither block emit nor the fast subscriber
+	for i := 0; i < 2*eventBufferSize; i++ {
+		app.emit(ControlCodeEvent{Code: byte(i)})
+		event := <-fast
+		if event != (ControlCodeEvent{Code: byte(i)}) {
+			t.Fatalf("unexpected event %#v", event)
+		}
+	}
+
+	if len(slow) != eventBufferSize {
+		t.Errorf("expected %d buffered events, got %d", eventBufferSize, len(slow))
+	}
+	if event := <-slow; event != (ControlCodeEvent{Code: 0}) {
+		t.Errorf("expected the oldest event to be kept, got %#v", event)
+	}
+}
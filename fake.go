@@ -0,0 +1,192 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"tinygo.org/x/bluetooth"
+)
+
+// fakeAdapter simulates a single walking pad. If it is created with scripted statuses, every status request is
+// answered with the next of them and the last status is repeated once the script is exhausted. Otherwise, the pad
+// reacts to commands and generates increasing stats while its belt is running. It allows running the app without
+// hardware.
+type fakeAdapter struct {
+	statuses []WalkingPadStatus
+
+	mu             sync.Mutex
+	scanning       chan struct{}
+	connectHandler func(address bluetooth.Address, connected bool)
+}
+
+func newFakeAdapter(statuses []WalkingPadStatus) *fakeAdapter {
+	return &fakeAdapter{statuses: statuses}
+}
+
+func (a *fakeAdapter) Enable() error {
+	return nil
+}
+
+func (a *fakeAdapter) Scan(callback func(device bluetooth.ScanResult)) error {
+	a.mu.Lock()
+	a.scanning = make(chan struct{})
+	scanning := a.scanning
+	a.mu.Unlock()
+
+	callback(bluetooth.ScanResult{AdvertisementPayload: fakeAdvertisement{}})
+
+	<-scanning
+	return nil
+}
+
+func (a *fakeAdapter) StopScan() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.scanning != nil {
+		close(a.scanning)
+		a.scanning = nil
+	}
+	return nil
+}
+
+func (a *fakeAdapter) Connect(address bluetooth.Address) (PadConnection, error) {
+	return &fakeConnection{
+		adapter: a,
+		address: address,
+		status:  WalkingPadStatus{Mode: WalkingPadModeManual},
+	}, nil
+}
+
+func (a *fakeAdapter) ConnectHeartRate(bluetooth.Address) (HeartRateConnection, error) {
+	return nil, errors.New("heart rate monitors are not simulated")
+}
+
+func (a *fakeAdapter) SetConnectHandler(handler func(address bluetooth.Address, connected bool)) {
+	a.connectHandler = handler
+}
+
+// fakeAdvertisement advertises the walking pad service.
+type fakeAdvertisement struct{}
+
+func (fakeAdvertisement) LocalName() string { return "WalkingPad" }
+
+func (fakeAdvertisement) HasServiceUUID(uuid bluetooth.UUID) bool { return uuid == walkingPadUUIDs[0] }
+
+func (fakeAdvertisement) Bytes() []byte { return nil }
+
+func (fakeAdvertisement) ManufacturerData() []bluetooth.ManufacturerDataElement { return nil }
+
+func (fakeAdvertisement) ServiceData() []bluetooth.ServiceDataElement { return nil }
+
+type fakeConnection struct {
+	adapter *fakeAdapter
+	address bluetooth.Address
+
+	mu      sync.Mutex
+	handler func(buf []byte)
+	next    int
+
+	// simulated state, if no statuses are scripted
+	status    WalkingPadStatus
+	updatedAt time.Time
+}
+
+func (conn *fakeConnection) Write(buf []byte) error {
+	if len(buf) < 4 || buf[0] != 247 || buf[1] != 162 {
+		return nil // preferences are accepted but have no effect
+	}
+
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+
+	if len(conn.adapter.statuses) == 0 {
+		conn.simulate(buf[2], buf[3])
+	}
+	if buf[2] != 0 || conn.handler == nil {
+		return nil // only status requests are answered
+	}
+
+	status := conn.status
+	if len(conn.adapter.statuses) != 0 {
+		status = conn.adapter.statuses[min(conn.next, len(conn.adapter.statuses)-1)]
+		conn.next++
+	}
+
+	go conn.handler(encodeStatusFrame(status))
+	return nil
+}
+
+const (
+	fakeStartSpeed = 2.0
+	fakeStepsPerKM = 1400.0
+)
+
+// simulate advances the stats by the time passed since the last command and applies the command.
+func (conn *fakeConnection) simulate(cmd, value byte) {
+	now := time.Now()
+	if !conn.updatedAt.IsZero() && conn.status.BeltState == WalkingPadBeltStateRunning {
+		elapsed := now.Sub(conn.updatedAt)
+		km := conn.status.Speed * elapsed.Hours()
+		conn.status.Time += elapsed
+		conn.status.WalkedKM += km
+		conn.status.Steps += int(km * fakeStepsPerKM)
+	}
+	conn.updatedAt = now
+
+	switch cmd {
+	case 1: // change speed
+		conn.status.Speed = float64(value) / 10.0
+		if conn.status.Speed == 0 {
+			conn.status.BeltState = WalkingPadBeltStateIdle
+		}
+	case 2: // change mode
+		conn.status.Mode = WalkingPadMode(value)
+	case 4: // start belt
+		conn.status.BeltState = WalkingPadBeltStateRunning
+		conn.status.Speed = fakeStartSpeed
+	}
+}
+
+func (conn *fakeConnection) Notify(handler func(buf []byte)) error {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+
+	conn.handler = handler
+	return nil
+}
+
+func (conn *fakeConnection) ReadModel() (string, error) {
+	return "fake", nil
+}
+
+func (conn *fakeConnection) Service() bluetooth.UUID {
+	return walkingPadUUIDs[0]
+}
+
+func (conn *fakeConnection) Disconnect() error {
+	if handler := conn.adapter.connectHandler; handler != nil {
+		go handler(conn.address, false)
+	}
+	return nil
+}
+
+// encodeStatusFrame encodes a status as it is sent by the device. It is the inverse of readStatusBuffer.
+func encodeStatusFrame(status WalkingPadStatus) []byte {
+	timeS := int(status.Time.Seconds())
+	dist := int(status.WalkedKM * 100.0)
+	frame := []byte{
+		248, 162,
+		byte(status.BeltState),
+		byte(status.Speed * 10.0),
+		byte(status.Mode),
+		byte(timeS >> 16), byte(timeS >> 8), byte(timeS),
+		byte(dist >> 16), byte(dist >> 8), byte(dist),
+		byte(status.Steps >> 16), byte(status.Steps >> 8), byte(status.Steps),
+		byte(status.Speed * 10.0), 0, status.ControlCode,
+		0xFF, 253,
+	}
+	fixCrc(frame)
+	return frame
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FIT files are encoded according to the Garmin FIT protocol. Only the messages required for Garmin Connect to
+// import a summary of an activity are written: file_id, lap, session, and activity.
+// See: https://developer.garmin.com/fit/protocol/
+
+const (
+	fitProtocolVersion = 0x10
+	fitProfileVersion  = 2100
+
+	fitMesgFileID   = 0
+	fitMesgSession  = 18
+	fitMesgLap      = 19
+	fitMesgActivity = 34
+
+	fitBaseEnum   = 0x00
+	fitBaseUint16 = 0x84
+	fitBaseUint32 = 0x86
+
+	fitFileActivity          = 4
+	fitManufacturerDev       = 255
+	fitSportWalking          = 11
+	fitEventSession          = 8
+	fitEventLap              = 9
+	fitEventActivity         = 26
+	fitEventTypeStop         = 1
+	fitActivityTypeManual    = 0
+	fitFieldTimestamp        = 253
+	fitTimestampEpochSeconds = 631065600 // 1989-12-31T00:00:00Z
+)
+
+var fitCrcTable = [16]uint16{
+	0x0000, 0xCC01, 0xD801, 0x1400, 0xF001, 0x3C00, 0x2800, 0xE401,
+	0xA001, 0x6C00, 0x7800, 0xB401, 0x5000, 0x9C01, 0x8801, 0x4400,
+}
+
+// fitCrc computes the CRC-16 used for FIT file headers and trailers.
+func fitCrc(crc uint16, data []byte) uint16 {
+	for _, b := range data {
+		tmp := fitCrcTable[crc&0xF]
+		crc = (crc >> 4) & 0x0FFF
+		crc = crc ^ tmp ^ fitCrcTable[b&0xF]
+
+		tmp = fitCrcTable[crc&0xF]
+		crc = (crc >> 4) & 0x0FFF
+		crc = crc ^ tmp ^ fitCrcTable[(b>>4)&0xF]
+	}
+	return crc
+}
+
+func fitTimestamp(t time.Time) uint32 {
+	return uint32(t.Unix() - fitTimestampEpochSeconds)
+}
+
+type fitField struct {
+	num      byte
+	baseType byte
+	value    any // uint8, uint16 or uint32
+}
+
+func (f fitField) size() byte {
+	switch f.value.(type) {
+	case uint8:
+		return 1
+	case uint16:
+		return 2
+	default:
+		return 4
+	}
+}
+
+// writeFitMessage writes a definition message followed by a single data message. Every message uses its own local
+// message type, so definitions never need to be redefined.
+func writeFitMessage(buf *bytes.Buffer, localType byte, globalNum uint16, fields []fitField) {
+	buf.WriteByte(0x40 | localType)
+	buf.WriteByte(0) // reserved
+	buf.WriteByte(0) // little endian
+	_ = binary.Write(buf, binary.LittleEndian, globalNum)
+	buf.WriteByte(byte(len(fields)))
+	for _, f := range fields {
+		buf.Write([]byte{f.num, f.size(), f.baseType})
+	}
+
+	buf.WriteByte(localType)
+	for _, f := range fields {
+		_ = binary.Write(buf, binary.LittleEndian, f.value)
+	}
+}
+
+// fitSession is the summary of a walking session that is encoded into a FIT activity file.
+type fitSession struct {
+	StartAt    time.Time
+	EndAt      time.Time
+	Duration   time.Duration
+	DistanceKm float64
+	Steps      int
+}
+
+// writeFitActivity encodes the session as a FIT activity file with a single lap.
+func writeFitActivity(w io.Writer, session fitSession) error {
+	var (
+		start    = fitTimestamp(session.StartAt)
+		end      = fitTimestamp(session.EndAt)
+		elapsed  = uint32(session.EndAt.Sub(session.StartAt).Milliseconds())
+		timer    = uint32(session.Duration.Milliseconds())
+		distance = uint32(session.DistanceKm * 1000 * 100)
+		strides  = uint32(session.Steps / 2) // walking cycles are counted in strides, i.e. two steps
+	)
+
+	var data bytes.Buffer
+	writeFitMessage(&data, 0, fitMesgFileID, []fitField{
+		{num: 0, baseType: fitBaseEnum, value: uint8(fitFileActivity)},
+		{num: 1, baseType: fitBaseUint16, value: uint16(fitManufacturerDev)},
+		{num: 2, baseType: fitBaseUint16, value: uint16(0)},
+		{num: 4, baseType: fitBaseUint32, value: start},
+	})
+	writeFitMessage(&data, 1, fitMesgLap, []fitField{
+		{num: fitFieldTimestamp, baseType: fitBaseUint32, value: end},
+		{num: 0, baseType: fitBaseEnum, value: uint8(fitEventLap)},
+		{num: 1, baseType: fitBaseEnum, value: uint8(fitEventTypeStop)},
+		{num: 2, baseType: fitBaseUint32, value: start},
+		{num: 7, baseType: fitBaseUint32, value: elapsed},
+		{num: 8, baseType: fitBaseUint32, value: timer},
+		{num: 9, baseType: fitBaseUint32, value: distance},
+		{num: 10, baseType: fitBaseUint32, value: strides},
+		{num: 25, baseType: fitBaseEnum, value: uint8(fitSportWalking)},
+	})
+	writeFitMessage(&data, 2, fitMesgSession, []fitField{
+		{num: fitFieldTimestamp, baseType: fitBaseUint32, value: end},
+		{num: 0, baseType: fitBaseEnum, value: uint8(fitEventSession)},
+		{num: 1, baseType: fitBaseEnum, value: uint8(fitEventTypeStop)},
+		{num: 2, baseType: fitBaseUint32, value: start},
+		{num: 5, baseType: fitBaseEnum, value: uint8(fitSportWalking)},
+		{num: 7, baseType: fitBaseUint32, value: elapsed},
+		{num: 8, baseType: fitBaseUint32, value: timer},
+		{num: 9, baseType: fitBaseUint32, value: distance},
+		{num: 10, baseType: fitBaseUint32, value: strides},
+		{num: 25, baseType: fitBaseUint16, value: uint16(0)},
+		{num: 26, baseType: fitBaseUint16, value: uint16(1)},
+	})
+	writeFitMessage(&data, 3, fitMesgActivity, []fitField{
+		{num: fitFieldTimestamp, baseType: fitBaseUint32, value: end},
+		{num: 0, baseType: fitBaseUint32, value: timer},
+		{num: 1, baseType: fitBaseUint16, value: uint16(1)},
+		{num: 2, baseType: fitBaseEnum, value: uint8(fitActivityTypeManual)},
+		{num: 3, baseType: fitBaseEnum, value: uint8(fitEventActivity)},
+		{num: 4, baseType: fitBaseEnum, value: uint8(fitEventTypeStop)},
+	})
+
+	var header bytes.Buffer
+	header.WriteByte(14)
+	header.WriteByte(fitProtocolVersion)
+	_ = binary.Write(&header, binary.LittleEndian, uint16(fitProfileVersion))
+	_ = binary.Write(&header, binary.LittleEndian, uint32(data.Len()))
+	header.WriteString(".FIT")
+	_ = binary.Write(&header, binary.LittleEndian, fitCrc(0, header.Bytes()))
+
+	crc := fitCrc(0, header.Bytes())
+	crc = fitCrc(crc, data.Bytes())
+
+	for _, b := range [][]byte{header.Bytes(), data.Bytes(), {byte(crc), byte(crc >> 8)}} {
+		_, err := w.Write(b)
+		if err != nil {
+			return fmt.Errorf("write fit file: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// writeFitFile writes the session as a FIT activity file into dir, which is created if it does not exist. Files are
+// named after the session start. It returns the path of the written file.
+func writeFitFile(dir string, session fitSession) (string, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return "", fmt.Errorf("failed to create export dir: %w", err)
+	}
+
+	fileName := fmt.Sprintf("walkingpad_%s.fit", session.StartAt.Format("20060102_150405"))
+	filePath := filepath.Join(dir, fileName)
+
+	fitFile, err := os.Create(filePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to create fit file: %w", err)
+	}
+	defer func() { _ = fitFile.Close() }()
+
+	err = writeFitActivity(fitFile, session)
+	if err != nil {
+		return "", err
+	}
+	return filePath, fitFile.Close()
+}
+
+// exportSessionFit writes a session of the session history as a FIT activity file into dir. Sessions are numbered
+// from 1, oldest first, like in GET /sessions.
+func exportSessionFit(dir string, id int) (string, error) {
+	sessions, err := readSessions()
+	if err != nil {
+		return "", err
+	}
+	session, ok := findSession(sessions, id)
+	if !ok {
+		return "", fmt.Errorf("session %d not found", id)
+	}
+
+	return writeFitFile(dir, fitSession{
+		StartAt:    session.StartAt,
+		EndAt:      session.EndAt,
+		Duration:   time.Duration(session.DurationMin * float64(time.Minute)),
+		DistanceKm: session.DistanceKm,
+		Steps:      session.Steps,
+	})
+}
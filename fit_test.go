@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestFitCrc(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want uint16
+	}{
+		{name: "empty", data: nil, want: 0x0000},
+		// the FIT checksum is a CRC-16/ARC, whose check value is defined for this input
+		{name: "check value", data: []byte("123456789"), want: 0xBB3D},
+		{name: "single byte", data: []byte{0x01}, want: 0xC0C1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := fitCrc(0, tt.data)
+			if got != tt.want {
+				t.Errorf("expected crc %#04x, got %#04x", tt.want, got)
+			}
+		})
+	}
+}
+
+// fitRecord is a decoded data message of a FIT file, with the values of its fields by field number.
+type fitRecord struct {
+	globalNum uint16
+	fields    map[byte]uint32
+}
+
+// decodeFitRecords decodes the data messages of a FIT file body. It only supports the subset written by
+// writeFitActivity, i.e. little endian messages without compressed timestamps or developer fields.
+func decodeFitRecords(t *testing.T, data []byte) []fitRecord {
+	t.Helper()
+
+	type definition struct {
+		globalNum uint16
+		fields    [][2]byte // field number and size
+	}
+	definitions := make(map[byte]definition)
+
+	var records []fitRecord
+	r := bytes.NewReader(data)
+	for r.Len() > 0 {
+		header, _ := r.ReadByte()
+		localType := header & 0x0F
+
+		if header&0x40 != 0 {
+			var def struct {
+				Reserved, Arch byte
+				GlobalNum      uint16
+				NumFields      byte
+			}
+			if err := binary.Read(r, binary.LittleEndian, &def); err != nil {
+				t.Fatalf("read definition: %v", err)
+			}
+			d := definition{globalNum: def.GlobalNum}
+			for i := 0; i < int(def.NumFields); i++ {
+				var field [3]byte
+				if _, err := r.Read(field[:]); err != nil {
+					t.Fatalf("read field definition: %v", err)
+				}
+				d.fields = append(d.fields, [2]byte{field[0], field[1]})
+			}
+			definitions[localType] = d
+			continue
+		}
+
+		d, ok := definitions[localType]
+		if !ok {
+			t.Fatalf("data message of undefined local type %d", localType)
+		}
+		record := fitRecord{globalNum: d.globalNum, fields: make(map[byte]uint32)}
+		for _, field := range d.fields {
+			buf := make([]byte, 4)
+			if _, err := r.Read(buf[:field[1]]); err != nil {
+				t.Fatalf("read field %d: %v", field[0], err)
+			}
+			record.fields[field[0]] = binary.LittleEndian.Uint32(buf)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestWriteFitActivity(t *testing.T) {
+	startAt := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+	session := fitSession{
+		StartAt:    startAt,
+		EndAt:      startAt.Add(40 * time.Minute),
+		Duration:   30 * time.Minute,
+		DistanceKm: 2.5,
+		Steps:      3001,
+	}
+
+	var buf bytes.Buffer
+	err := writeFitActivity(&buf, session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := buf.Bytes()
+
+	if len(file) < 16 {
+		t.Fatalf("file too short: %d bytes", len(file))
+	}
+	header, body := file[:14], file[14:len(file)-2]
+	if header[0] != 14 {
+		t.Errorf("expected header size 14, got %d", header[0])
+	}
+	if header[1] != fitProtocolVersion {
+		t.Errorf("expected protocol version %#x, got %#x", fitProtocolVersion, header[1])
+	}
+	if got := binary.LittleEndian.Uint16(header[2:4]); got != fitProfileVersion {
+		t.Errorf("expected profile version %d, got %d", fitProfileVersion, got)
+	}
+	if got := binary.LittleEndian.Uint32(header[4:8]); int(got) != len(body) {
+		t.Errorf("expected data size %d, got %d", len(body), got)
+	}
+	if string(header[8:12]) != ".FIT" {
+		t.Errorf("expected data type .FIT, got %q", header[8:12])
+	}
+
+	// a CRC over data followed by its own little endian CRC is zero
+	if crc := fitCrc(0, header); crc != 0 {
+		t.Errorf("invalid header crc: residue %#04x", crc)
+	}
+	if crc := fitCrc(0, file); crc != 0 {
+		t.Errorf("invalid file crc: residue %#04x", crc)
+	}
+
+	records := decodeFitRecords(t, body)
+	var globalNums []uint16
+	for _, record := range records {
+		globalNums = append(globalNums, record.globalNum)
+	}
+	wantNums := []uint16{fitMesgFileID, fitMesgLap, fitMesgSession, fitMesgActivity}
+	if !slices.Equal(globalNums, wantNums) {
+		t.Fatalf("expected messages %v, got %v", wantNums, globalNums)
+	}
+
+	start := uint32(startAt.Unix() - fitTimestampEpochSeconds)
+	sessionFields := records[2].fields
+	for _, tt := range []struct {
+		name  string
+		field byte
+		want  uint32
+	}{
+		{name: "timestamp", field: fitFieldTimestamp, want: start + 40*60},
+		{name: "start_time", field: 2, want: start},
+		{name: "sport", field: 5, want: fitSportWalking},
+		{name: "total_elapsed_time", field: 7, want: 40 * 60 * 1000},
+		{name: "total_timer_time", field: 8, want: 30 * 60 * 1000},
+		{name: "total_distance", field: 9, want: 2500 * 100},
+		{name: "total_cycles", field: 10, want: 1500},
+	} {
+		if got := sessionFields[tt.field]; got != tt.want {
+			t.Errorf("session %s: expected %d, got %d", tt.name, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/getlantern/systray"
+)
+
+type goalState struct {
+	stepGoalEnabled     bool
+	durationGoalEnabled bool
+	distanceGoalEnabled bool
+
+	// reached flags prevent goals from firing repeatedly while the belt coasts to a stop
+	stepGoalReached     bool
+	durationGoalReached bool
+	distanceGoalReached bool
+}
+
+func (app *App) setupGoalsUI() {
+	if app.StepGoal == nil && app.DurationGoal == nil && app.DistanceGoalKm == nil {
+		return
+	}
+
+	mGoals := systray.AddMenuItem("Goals", "")
+	if app.StepGoal != nil {
+		app.goals.stepGoalEnabled = true
+		app.addGoalToggle(mGoals, fmt.Sprintf("%d steps", *app.StepGoal), &app.goals.stepGoalEnabled)
+	}
+	if app.DurationGoal != nil {
+		app.goals.durationGoalEnabled = true
+		app.addGoalToggle(mGoals, app.DurationGoal.String(), &app.goals.durationGoalEnabled)
+	}
+	if app.DistanceGoalKm != nil {
+		app.goals.distanceGoalEnabled = true
+		app.addGoalToggle(mGoals, app.formatDistance(*app.DistanceGoalKm), &app.goals.distanceGoalEnabled)
+	}
+}
+
+func (app *App) addGoalToggle(parent *systray.MenuItem, title string, enabled *bool) {
+	item := parent.AddSubMenuItemCheckbox(title, "", *enabled)
+	item.ClickedCh = make(chan struct{})
+	go func() {
+		for {
+			<-item.ClickedCh
+			app.mu.Lock()
+			*enabled = !*enabled
+			if *enabled {
+				item.Check()
+			} else {
+				item.Uncheck()
+			}
+			app.mu.Unlock()
+		}
+	}()
+}
+
+// checkGoals stops the belt once an enabled goal is reached. All goals are checked at the same time, and the first
+// one reached stops the belt. Every goal triggers at most once per session.
+func (app *App) checkGoals() {
+	if !app.state.started {
+		return
+	}
+
+	if app.StepGoal != nil && app.goals.stepGoalEnabled && !app.goals.stepGoalReached &&
+		app.state.stepsAccumTotal >= *app.StepGoal {
+		app.goals.stepGoalReached = true
+		slog.Info("step goal reached", "goal", *app.StepGoal, "steps", app.state.stepsAccumTotal)
+		app.stopForGoal(fmt.Sprintf("%d steps", *app.StepGoal))
+		return
+	}
+
+	if app.DurationGoal != nil && app.goals.durationGoalEnabled && !app.goals.durationGoalReached &&
+		app.state.timeAccumTotal >= *app.DurationGoal {
+		app.goals.durationGoalReached = true
+		slog.Info("duration goal reached", "goal", *app.DurationGoal, "duration", app.state.timeAccumTotal)
+		app.stopForGoal(app.DurationGoal.String())
+		return
+	}
+
+	if app.DistanceGoalKm != nil && app.goals.distanceGoalEnabled && !app.goals.distanceGoalReached &&
+		app.state.kmAccumTotal >= *app.DistanceGoalKm {
+		app.goals.distanceGoalReached = true
+		slog.Info("distance goal reached", "goal", *app.DistanceGoalKm, "km", app.state.kmAccumTotal)
+		app.stopForGoal(app.formatDistance(*app.DistanceGoalKm))
+		return
+	}
+}
+
+// stopForGoal stops the belt like a pause would, so the webhook is sent for the completed session, and notifies the
+// user about the reached goal.
+func (app *App) stopForGoal(goal string) {
+	err := app.pauseBelt()
+	if err != nil {
+		slog.Error("pauseBelt", "err", err)
+	}
+
+	err = notify("WalkingPad", fmt.Sprintf("Goal reached: %s", goal))
+	if err != nil {
+		slog.Error("notify", "err", err)
+	}
+}
+
+func (app *App) formatDistance(km float64) string {
+	return fmt.Sprintf("%.2f %s", app.Units.Distance(km), app.Units.DistanceUnit())
+}
+
+// remainingDuration returns the time left until the duration goal is reached, if the goal is active.
+func (app *App) remainingDuration() (time.Duration, bool) {
+	if app.DurationGoal == nil || !app.goals.durationGoalEnabled || app.goals.durationGoalReached {
+		return 0, false
+	}
+	return max(0, *app.DurationGoal-app.state.timeAccumTotal), true
+}
+
+func (app *App) resetGoals() {
+	app.goals.stepGoalReached = false
+	app.goals.durationGoalReached = false
+	app.goals.distanceGoalReached = false
+}
+
+// formatClock formats the duration as mm:ss, or h:mm:ss if it exceeds an hour.
+func formatClock(d time.Duration) string {
+	d = d.Round(time.Second)
+	h := int(d.Hours())
+	m := int(d.Minutes()) % 60
+	s := int(d.Seconds()) % 60
+	if h > 0 {
+		return fmt.Sprintf("%d:%02d:%02d", h, m, s)
+	}
+	return fmt.Sprintf("%02d:%02d", m, s)
+}
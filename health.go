@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// healthCSVHeader lists the columns of the health export, which can be read by the Health Importer shortcut on macOS.
+var healthCSVHeader = []string{"start_date", "end_date", "duration_sec", "distance_m", "steps"}
+
+// exportHealth writes the current session as a CSV file with a single workout into HealthExportDir.
+func (app *App) exportHealth() error {
+	if app.HealthExportDir == nil || app.state.startedAt.IsZero() {
+		return nil
+	}
+
+	err := os.MkdirAll(*app.HealthExportDir, 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create export dir: %w", err)
+	}
+
+	fileName := fmt.Sprintf("walkingpad_%s.csv", app.state.startedAt.Format("20060102_150405"))
+	filePath := filepath.Join(*app.HealthExportDir, fileName)
+
+	f, err := os.Create(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to create health file: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	w := csv.NewWriter(f)
+	_ = w.Write(healthCSVHeader)
+	_ = w.Write([]string{
+		app.state.startedAt.Format(time.RFC3339),
+		app.state.pausedAt.Format(time.RFC3339),
+		strconv.Itoa(int(app.state.timeAccum.Seconds())),
+		strconv.FormatFloat(app.state.kmAccum*1000, 'f', 0, 64),
+		strconv.Itoa(app.state.stepsAccum),
+	})
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return fmt.Errorf("failed to write health file: %w", err)
+	}
+
+	slog.Info("exported health file", "path", filePath)
+
+	return nil
+}
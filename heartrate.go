@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"tinygo.org/x/bluetooth"
+)
+
+const (
+	heartRateRetryDelay = 30 * time.Second
+	// heartRateStaleAfter is how long a measurement is used before the monitor is considered out of range.
+	heartRateStaleAfter = 5 * time.Second
+)
+
+// HeartRateConnection is an established connection to a monitor that implements the standard heart rate service.
+type HeartRateConnection interface {
+	// Notify registers the handler that receives every measured heart rate in beats per minute.
+	Notify(handler func(bpm int)) error
+	Disconnect() error
+}
+
+// parseHeartRateMeasurement decodes the heart rate from a heart rate measurement value. The lowest bit of the flags
+// defines if it is sent as one or two bytes.
+// See: https://www.bluetooth.com/specifications/specs/heart-rate-service-1-0/
+func parseHeartRateMeasurement(buf []byte) (int, bool) {
+	if len(buf) < 2 {
+		return 0, false
+	}
+	if buf[0]&0x01 == 0 {
+		return int(buf[1]), true
+	}
+	if len(buf) < 3 {
+		return 0, false
+	}
+	return int(binary.LittleEndian.Uint16(buf[1:3])), true
+}
+
+// heartRateState holds the latest measurement, which is written by the bluetooth notification handler and read by the
+// main loop.
+type heartRateState struct {
+	mu         sync.Mutex
+	bpm        int
+	measuredAt time.Time
+}
+
+func (s *heartRateState) set(bpm int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bpm = bpm
+	s.measuredAt = time.Now()
+}
+
+// current returns the latest heart rate, or 0 if there was no recent measurement.
+func (s *heartRateState) current() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if time.Since(s.measuredAt) > heartRateStaleAfter {
+		return 0
+	}
+	return s.bpm
+}
+
+// heartRateStats aggregates the heart rate of a session.
+type heartRateStats struct {
+	sum, samples, max int
+}
+
+func (s *heartRateStats) add(bpm int) {
+	s.sum += bpm
+	s.samples++
+	s.max = max(s.max, bpm)
+}
+
+func (s heartRateStats) avg() float64 {
+	if s.samples == 0 {
+		return 0
+	}
+	return float64(s.sum) / float64(s.samples)
+}
+
+// connectHeartRateLoop keeps the heart rate monitor connected, retrying every heartRateRetryDelay. Unlike the pad, the
+// monitor is never scanned for, so that it does not interfere with scanning for pads.
+func (app *App) connectHeartRateLoop(device string) {
+	address, ok := parseAddress(device)
+	if !ok {
+		slog.Error("invalid heart rate device", "device", device)
+		return
+	}
+
+	for {
+		conn, err := app.Adapter.ConnectHeartRate(address)
+		if err == nil {
+			err = conn.Notify(app.heartRate.set)
+			if err != nil {
+				_ = conn.Disconnect()
+			}
+		}
+		if err != nil {
+			slog.Error("connect heart rate monitor", "device", device, "err", err)
+			time.Sleep(heartRateRetryDelay)
+			continue
+		}
+
+		slog.Info("connected heart rate monitor", "device", device)
+		<-app.heartRateLost
+		slog.Info("heart rate monitor disconnected", "device", device)
+		_ = conn.Disconnect()
+	}
+}
+
+// onHeartRateDisconnected wakes up connectHeartRateLoop to reconnect the monitor.
+func (app *App) onHeartRateDisconnected() {
+	select {
+	case app.heartRateLost <- struct{}{}:
+	default:
+	}
+}
+
+func (a *bluetoothAdapter) ConnectHeartRate(address bluetooth.Address) (HeartRateConnection, error) {
+	device, err := a.adapter.Connect(address, bluetooth.ConnectionParams{})
+	if err != nil {
+		return nil, fmt.Errorf("connect: %w", err)
+	}
+
+	services, err := device.DiscoverServices([]bluetooth.UUID{bluetooth.ServiceUUIDHeartRate})
+	if err == nil && len(services) == 0 {
+		err = errors.New("not found")
+	}
+	if err != nil {
+		_ = device.Disconnect()
+		return nil, fmt.Errorf("discover heart rate service: %w", err)
+	}
+	characteristics, err := services[0].DiscoverCharacteristics([]bluetooth.UUID{bluetooth.CharacteristicUUIDHeartRateMeasurement})
+	if err == nil && len(characteristics) == 0 {
+		err = errors.New("not found")
+	}
+	if err != nil {
+		_ = device.Disconnect()
+		return nil, fmt.Errorf("discover heart rate measurement: %w", err)
+	}
+
+	return &bluetoothHeartRateConnection{device: device, measurement: characteristics[0]}, nil
+}
+
+type bluetoothHeartRateConnection struct {
+	device      bluetooth.Device
+	measurement bluetooth.DeviceCharacteristic
+}
+
+func (conn *bluetoothHeartRateConnection) Notify(handler func(bpm int)) error {
+	return conn.measurement.EnableNotifications(func(buf []byte) {
+		bpm, ok := parseHeartRateMeasurement(buf)
+		if ok && bpm > 0 {
+			handler(bpm)
+		}
+	})
+}
+
+func (conn *bluetoothHeartRateConnection) Disconnect() error {
+	return conn.device.Disconnect()
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+)
+
+// sessionLogLine is a completed session, as stored in the session history.
+type sessionLogLine struct {
+	// ID is the line number of the session in the log, which stays the same if other lines are malformed. It is not
+	// written to the log.
+	ID int `json:"-"`
+
+	StartAt     time.Time `json:"start_ts"`
+	EndAt       time.Time `json:"end_ts"`
+	DurationMin float64   `json:"duration_min"`
+	MovingMin   float64   `json:"moving_min"`
+	ElapsedMin  float64   `json:"elapsed_min"`
+	Steps       int       `json:"steps"`
+	DistanceKm  float64   `json:"distance_km"`
+	Calories    float64   `json:"calories"`
+	AvgHR       float64   `json:"avg_hr,omitempty"`
+	MaxHR       int       `json:"max_hr,omitempty"`
+	// SpeedBreakdown is the moving time in minutes per speed in km/h.
+	SpeedBreakdown map[string]float64 `json:"speed_breakdown,omitempty"`
+}
+
+// findSession returns the session with the given ID.
+func findSession(sessions []sessionLogLine, id int) (sessionLogLine, bool) {
+	for _, session := range sessions {
+		if session.ID == id {
+			return session, true
+		}
+	}
+	return sessionLogLine{}, false
+}
+
+func sessionLogPath() (string, error) {
+	return configSiblingPath("_sessions.jsonl")
+}
+
+// appendJSONL appends v as a single JSON line to the file at path. The file is created if it does not exist.
+func appendJSONL(path string, v any) error {
+	line, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to marshal log line: %w", err)
+	}
+
+	logFile, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	defer func() { _ = logFile.Close() }()
+
+	_, err = logFile.WriteString(string(line) + "\n")
+	if err != nil {
+		return fmt.Errorf("failed to write to log file: %w", err)
+	}
+
+	return nil
+}
+
+// logSession appends the current session to the session history.
+func (app *App) logSession() error {
+	logPath, err := sessionLogPath()
+	if err != nil {
+		return err
+	}
+
+	return appendJSONL(logPath, sessionLogLine{
+		StartAt:     app.state.startedAt,
+		EndAt:       app.state.pausedAt,
+		DurationMin: app.state.timeAccum.Minutes(),
+		MovingMin:   app.state.timeAccum.Minutes(),
+		ElapsedMin:  app.sessionElapsed().Minutes(),
+		Steps:       app.state.stepsAccum,
+		DistanceKm:  app.state.kmAccum,
+		Calories:    estimateCalories(app.state.kmAccum, app.state.timeAccum, app.bodyWeightKg()),
+		AvgHR:       app.state.heartRate.avg(),
+		MaxHR:       app.state.heartRate.max,
+
+		SpeedBreakdown: app.state.speedBreakdown(),
+	})
+}
+
+// historyRefreshInterval is how often the daily and weekly totals are recomputed from the session history, so that
+// they roll over at midnight even if no session is completed.
+const historyRefreshInterval = 5 * time.Minute
+
+// historyTotals are the distances of the completed sessions of the current day and week.
+type historyTotals struct {
+	TodayKm     float64
+	WeekKm      float64
+	refreshedAt time.Time
+}
+
+// refreshHistoryTotals recomputes the daily and weekly totals. Days start at midnight in the local timezone, and weeks
+// start on Monday.
+func (app *App) refreshHistoryTotals() {
+	now := time.Now()
+	// a failing read is only retried with the next refresh
+	app.history.refreshedAt = now
+
+	sessions, err := readSessions()
+	if err != nil {
+		slog.Error("readSessions", "err", err)
+		return
+	}
+
+	dayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	weekStart := dayStart.AddDate(0, 0, -((int(now.Weekday()) + 6) % 7))
+
+	totals := historyTotals{refreshedAt: now}
+	for _, session := range sessions {
+		if !session.StartAt.Before(dayStart) {
+			totals.TodayKm += session.DistanceKm
+		}
+		if !session.StartAt.Before(weekStart) {
+			totals.WeekKm += session.DistanceKm
+		}
+	}
+	app.history = totals
+}
+
+// exportSessionsCSV writes the session history to a CSV file, e.g. for spreadsheets. Times are in the local timezone.
+func exportSessionsCSV(path string) error {
+	sessions, err := readSessions()
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create csv file: %w", err)
+	}
+	defer func() { _ = file.Close() }()
+
+	w := csv.NewWriter(file)
+	_ = w.Write([]string{"date", "start_time", "duration_min", "steps", "distance_km"})
+	for _, session := range sessions {
+		startAt := session.StartAt.Local()
+		_ = w.Write([]string{
+			startAt.Format(time.DateOnly),
+			startAt.Format(time.TimeOnly),
+			strconv.FormatFloat(session.DurationMin, 'f', 2, 64),
+			strconv.Itoa(session.Steps),
+			strconv.FormatFloat(session.DistanceKm, 'f', 2, 64),
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return fmt.Errorf("failed to write csv file: %w", err)
+	}
+	return file.Close()
+}
+
+// readSessions returns all sessions from the session history, oldest first. A missing history yields no sessions.
+// readSessions reads all sessions of the log, oldest first. Malformed lines are skipped.
+func readSessions() ([]sessionLogLine, error) {
+	logPath, err := sessionLogPath()
+	if err != nil {
+		return nil, err
+	}
+
+	logFile, err := os.Open(logPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open log file: %w", err)
+	}
+	defer func() { _ = logFile.Close() }()
+
+	var sessions []sessionLogLine
+	scanner := bufio.NewScanner(logFile)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		var line sessionLogLine
+		err := json.Unmarshal(scanner.Bytes(), &line)
+		if err != nil {
+			slog.Warn("skip malformed session log line", "line", lineNumber, "err", err)
+			continue
+		}
+		line.ID = lineNumber
+		sessions = append(sessions, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read log file: %w", err)
+	}
+
+	return sessions, nil
+}
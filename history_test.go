@@ -0,0 +1,39 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadSessionsKeepsIDsOfMalformedLines(t *testing.T) {
+	configFile = filepath.Join(t.TempDir(), "walkingpad.json")
+	logPath, err := sessionLogPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(logPath, []byte(
+		`{"start_ts":"2024-01-01T10:00:00Z","end_ts":"2024-01-01T10:30:00Z","steps":100}`+"\n"+
+			`{"start_ts":`+"\n"+
+			`{"start_ts":"2024-01-02T10:00:00Z","end_ts":"2024-01-02T10:30:00Z","steps":300}`+"\n",
+	), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sessions, err := readSessions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 2 || sessions[0].ID != 1 || sessions[1].ID != 3 {
+		t.Fatalf("sessions = %+v, want ids 1 and 3", sessions)
+	}
+
+	session, ok := findSession(sessions, 3)
+	if !ok || session.Steps != 300 {
+		t.Errorf("findSession(3) = %+v, %v, want the session with 300 steps", session, ok)
+	}
+	if _, ok := findSession(sessions, 2); ok {
+		t.Error("findSession(2) found the malformed line")
+	}
+}
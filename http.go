@@ -0,0 +1,459 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultSessionsLimit = 50
+	maxSessionsLimit     = 500
+
+	speedCoalesceDelay = 300 * time.Millisecond
+)
+
+func (app *App) serveHTTP(addr string) {
+	slog.Info("start http server", "addr", addr)
+
+	err := http.ListenAndServe(addr, app.httpHandler())
+	if err != nil {
+		slog.Error("serveHTTP", "err", err)
+	}
+}
+
+func (app *App) httpHandler() http.Handler {
+	// every endpoint has its own limiter, so that a script hammering one endpoint does not lock out the others
+	limit := func(handler http.HandlerFunc) http.HandlerFunc {
+		return rateLimited(newRateLimiter(app.HTTPRateLimit, app.HTTPRateBurst), handler)
+	}
+
+	// handlers that read or change the state run one at a time, like the menu items and the main loop
+	locked := func(handler http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			app.mu.Lock()
+			defer app.mu.Unlock()
+			handler(w, r)
+		}
+	}
+
+	// rapid speed changes are coalesced into the last one, so the command queue of the pad does not back up
+	app.mu.Lock()
+	app.speedCoalescer = &coalescer{delay: speedCoalesceDelay}
+	app.stream = newStreamHub()
+	app.mu.Unlock()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /status", limit(locked(app.handleStatus)))
+	mux.HandleFunc("POST /start", limit(locked(app.handleStart)))
+	mux.HandleFunc("POST /stop", limit(locked(app.handleStop)))
+	mux.HandleFunc("POST /toggle", limit(locked(app.handleToggle)))
+	mux.HandleFunc("POST /speed", limit(locked(app.handleSpeed)))
+	mux.HandleFunc("GET /sessions", limit(app.handleListSessions))
+	mux.HandleFunc("GET /sessions/{id}", limit(app.handleGetSession))
+	mux.HandleFunc("GET /stream", limit(app.handleStream))
+	mux.HandleFunc("GET /config", limit(locked(app.handleGetConfig)))
+	mux.HandleFunc("PUT /config", limit(locked(app.handlePutConfig)))
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+type statusResponse struct {
+	ConnectionState string  `json:"connection_state"`
+	Device          string  `json:"device,omitempty"`
+	Model           string  `json:"model,omitempty"`
+	MatchedService  string  `json:"matched_service,omitempty"`
+	Started         bool    `json:"started"`
+	TargetSpeed     float64 `json:"target_speed"`
+	Speed           float64 `json:"speed"`
+	Mode            string  `json:"mode"`
+	ControlCode     byte    `json:"control_code"`
+	DurationMin     float64 `json:"duration_min"`
+	ElapsedMin      float64 `json:"elapsed_min"`
+	Steps           int     `json:"steps"`
+	DistanceKm      float64 `json:"distance_km"`
+	HeartRate       int     `json:"heart_rate,omitempty"`
+}
+
+func (app *App) statusResponse() statusResponse {
+	resp := statusResponse{
+		ConnectionState: app.state.conn.Current().String(),
+		Started:         app.state.started,
+		TargetSpeed:     app.TargetSpeed,
+		Speed:           app.state.status.Speed,
+		Mode:            app.state.status.Mode.String(),
+		ControlCode:     app.state.status.ControlCode,
+		DurationMin:     app.state.timeAccumTotal.Minutes(),
+		ElapsedMin:      elapsedSince(app.state.totalStartedAt).Minutes(),
+		Steps:           app.state.stepsAccumTotal,
+		DistanceKm:      app.state.kmAccumTotal,
+		HeartRate:       app.heartRate.current(),
+	}
+	if pad := app.pad; pad != nil {
+		resp.Device = pad.address.String()
+		resp.Model = pad.Model
+		resp.MatchedService = pad.MatchedService.String()
+	}
+	return resp
+}
+
+// requireReady rejects the request with 409 Conflict if the pad cannot be controlled.
+func (app *App) requireReady(w http.ResponseWriter) bool {
+	if !app.state.conn.Is(connectionStateReady) {
+		writeError(w, http.StatusConflict, fmt.Errorf("walking pad is not ready: %s", app.state.conn.Current()))
+		return false
+	}
+	return true
+}
+
+func (app *App) handleStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, app.statusResponse())
+}
+
+func (app *App) handleStart(w http.ResponseWriter, r *http.Request) {
+	if !app.requireReady(w) {
+		return
+	}
+	if !app.state.started {
+		app.cancelRamp()
+		err := app.startBelt()
+		if err != nil {
+			writeError(w, http.StatusConflict, err)
+			return
+		}
+		app.updateUI()
+	}
+	writeJSON(w, http.StatusOK, app.statusResponse())
+}
+
+// handleStop pauses the belt. If the cooldown query parameter is set, the speed is lowered gradually over the given
+// minutes before stopping.
+func (app *App) handleStop(w http.ResponseWriter, r *http.Request) {
+	if !app.requireReady(w) {
+		return
+	}
+
+	if value := r.URL.Query().Get("cooldown"); value != "" {
+		minutes, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid cooldown: %q", value))
+			return
+		}
+		cooldown, err := cooldownDuration(minutes)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		app.cooldownAndStop(cooldown)
+		writeJSON(w, http.StatusAccepted, app.statusResponse())
+		return
+	}
+
+	if app.state.started {
+		app.cancelRamp()
+		err := app.pauseBelt()
+		if err != nil {
+			writeError(w, http.StatusConflict, err)
+			return
+		}
+		app.updateUI()
+	}
+	writeJSON(w, http.StatusOK, app.statusResponse())
+}
+
+// handleToggle starts or pauses the belt, like the Start/Pause menu item. It allows binding a global hotkey to the
+// belt using the tools of the operating system.
+func (app *App) handleToggle(w http.ResponseWriter, r *http.Request) {
+	if !app.requireReady(w) {
+		return
+	}
+	err := app.toggleBelt()
+	if err != nil {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	app.updateUI()
+	writeJSON(w, http.StatusOK, app.statusResponse())
+}
+
+// handleSpeed sets the target speed. If the belt is running, the speed change is sent to the pad once no further
+// speed change arrived for speedCoalesceDelay.
+func (app *App) handleSpeed(w http.ResponseWriter, r *http.Request) {
+	pad, err := app.readyPad()
+	if err != nil {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+
+	value := r.URL.Query().Get("value")
+	speed, err := strconv.ParseFloat(value, 64)
+	if err != nil || speed <= 0 || speed > pad.profile.MaxSpeed {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid speed: %q", value))
+		return
+	}
+
+	// like selectSpeed, but the speed change is coalesced
+	app.setTargetSpeed(speed)
+	target := app.TargetSpeed
+
+	if app.state.started {
+		app.speedCoalescer.Do(func() {
+			app.mu.Lock()
+			defer app.mu.Unlock()
+			if app.state.conn.Is(connectionStateReady) && app.state.started {
+				app.cancelRamp()
+				err := app.changeSpeed(target)
+				if err != nil {
+					slog.Error("changeSpeed", "err", err)
+				}
+			}
+		})
+	}
+	writeJSON(w, http.StatusAccepted, app.statusResponse())
+}
+
+type sessionSummary struct {
+	ID          int       `json:"id"`
+	StartAt     time.Time `json:"start_ts"`
+	DurationMin float64   `json:"duration_min"`
+	Steps       int       `json:"steps"`
+	DistanceKm  float64   `json:"distance_km"`
+}
+
+type sessionDetail struct {
+	sessionSummary
+	EndAt time.Time `json:"end_ts"`
+}
+
+func queryInt(r *http.Request, key string, def int) (int, error) {
+	value := r.URL.Query().Get(key)
+	if value == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s: %q", key, value)
+	}
+	return n, nil
+}
+
+// handleListSessions lists the summaries of all completed sessions. The list is paginated using the offset and limit
+// query parameters.
+func (app *App) handleListSessions(w http.ResponseWriter, r *http.Request) {
+	offset, err := queryInt(r, "offset", 0)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	limit, err := queryInt(r, "limit", defaultSessionsLimit)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	limit = min(limit, maxSessionsLimit)
+
+	sessions, err := readSessions()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	summaries := make([]sessionSummary, 0, limit)
+	for i := offset; i < len(sessions) && len(summaries) < limit; i++ {
+		summaries = append(summaries, sessionSummary{
+			ID:          sessions[i].ID,
+			StartAt:     sessions[i].StartAt,
+			DurationMin: sessions[i].DurationMin,
+			Steps:       sessions[i].Steps,
+			DistanceKm:  sessions[i].DistanceKm,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"total":    len(sessions),
+		"offset":   offset,
+		"limit":    limit,
+		"sessions": summaries,
+	})
+}
+
+func (app *App) handleGetSession(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid session id: %q", r.PathValue("id")))
+		return
+	}
+
+	sessions, err := readSessions()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	session, ok := findSession(sessions, id)
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("session %d not found", id))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, sessionDetail{
+		sessionSummary: sessionSummary{
+			ID:          id,
+			StartAt:     session.StartAt,
+			DurationMin: session.DurationMin,
+			Steps:       session.Steps,
+			DistanceKm:  session.DistanceKm,
+		},
+		EndAt: session.EndAt,
+	})
+}
+
+// configPatch contains the settings that can be changed at runtime. Fields that are not set are left unchanged, and a
+// goal of 0 disables it.
+type configPatch struct {
+	TargetSpeed         *float64 `json:"targetSpeed"`
+	WebhookThresholdMin *float64 `json:"webhookThresholdMin"`
+	StepGoal            *int     `json:"stepGoal"`
+	DurationGoalMin     *float64 `json:"durationGoalMin"`
+	DistanceGoalKm      *float64 `json:"distanceGoalKm"`
+}
+
+// configResponse is the effective value of the settings that can be changed at runtime. Disabled goals are null.
+type configResponse struct {
+	TargetSpeed         float64  `json:"targetSpeed"`
+	WebhookThresholdMin float64  `json:"webhookThresholdMin"`
+	StepGoal            *int     `json:"stepGoal"`
+	DurationGoalMin     *float64 `json:"durationGoalMin"`
+	DistanceGoalKm      *float64 `json:"distanceGoalKm"`
+}
+
+func (app *App) configResponse() configResponse {
+	resp := configResponse{
+		TargetSpeed:         app.settings.defaultSpeed,
+		WebhookThresholdMin: app.WebhookThreshold.Minutes(),
+		StepGoal:            app.StepGoal,
+		DistanceGoalKm:      app.DistanceGoalKm,
+	}
+	if app.DurationGoal != nil {
+		minutes := app.DurationGoal.Minutes()
+		resp.DurationGoalMin = &minutes
+	}
+	return resp
+}
+
+func (app *App) handleGetConfig(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, app.configResponse())
+}
+
+// handlePutConfig validates the patch, persists it to the config file, and applies it to the running app. Nothing is
+// changed if any field is invalid.
+func (app *App) handlePutConfig(w http.ResponseWriter, r *http.Request) {
+	var patch configPatch
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields() // other fields cannot be changed at runtime
+	err := dec.Decode(&patch)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid config: %w", err))
+		return
+	}
+
+	var errs []error
+	fields := make(map[string]any)
+	if patch.TargetSpeed != nil {
+		maxSpeed := app.clampSpeed(app.maxDeviceSpeed())
+		if *patch.TargetSpeed <= 0 || *patch.TargetSpeed > maxSpeed {
+			errs = append(errs, fmt.Errorf("targetSpeed: must be within (0, %.1f]", maxSpeed))
+		}
+		fields["targetSpeed"] = *patch.TargetSpeed
+	}
+	if patch.WebhookThresholdMin != nil {
+		if *patch.WebhookThresholdMin < 0 {
+			errs = append(errs, errors.New("webhookThresholdMin: must not be negative"))
+		}
+		fields["webhookThresholdMin"] = *patch.WebhookThresholdMin
+	}
+	if patch.StepGoal != nil {
+		if *patch.StepGoal < 0 {
+			errs = append(errs, errors.New("stepGoal: must not be negative"))
+		}
+		fields["stepGoal"] = goalValue(*patch.StepGoal)
+	}
+	if patch.DurationGoalMin != nil {
+		if *patch.DurationGoalMin < 0 {
+			errs = append(errs, errors.New("durationGoalMin: must not be negative"))
+		}
+		fields["durationGoalMin"] = goalValue(*patch.DurationGoalMin)
+	}
+	if patch.DistanceGoalKm != nil {
+		if *patch.DistanceGoalKm < 0 {
+			errs = append(errs, errors.New("distanceGoalKm: must not be negative"))
+		}
+		fields["distanceGoalKm"] = goalValue(*patch.DistanceGoalKm)
+	}
+	if len(errs) != 0 {
+		writeError(w, http.StatusBadRequest, errors.Join(errs...))
+		return
+	}
+
+	if len(fields) != 0 {
+		err = updateConfig(fields)
+		if err != nil {
+			slog.Error("updateConfig", "err", err)
+			writeError(w, http.StatusInternalServerError, errors.New("failed to save config"))
+			return
+		}
+	}
+
+	if patch.TargetSpeed != nil {
+		// like the default speed in the settings menu, but the running belt follows the new speed right away
+		app.settings.defaultSpeed = *patch.TargetSpeed
+		app.selectSpeed(*patch.TargetSpeed)
+	}
+	if patch.WebhookThresholdMin != nil {
+		app.WebhookThreshold = time.Duration(*patch.WebhookThresholdMin * float64(time.Minute))
+	}
+	if patch.StepGoal != nil {
+		app.StepGoal = goalValue(*patch.StepGoal)
+		app.goals.stepGoalEnabled = app.StepGoal != nil
+		app.goals.stepGoalReached = false
+	}
+	if patch.DurationGoalMin != nil {
+		app.DurationGoal = nil
+		if *patch.DurationGoalMin > 0 {
+			goal := time.Duration(*patch.DurationGoalMin * float64(time.Minute))
+			app.DurationGoal = &goal
+		}
+		app.goals.durationGoalEnabled = app.DurationGoal != nil
+		app.goals.durationGoalReached = false
+	}
+	if patch.DistanceGoalKm != nil {
+		app.DistanceGoalKm = goalValue(*patch.DistanceGoalKm)
+		app.goals.distanceGoalEnabled = app.DistanceGoalKm != nil
+		app.goals.distanceGoalReached = false
+	}
+	app.updateSettingsUI()
+	app.updateUI()
+
+	writeJSON(w, http.StatusOK, app.configResponse())
+}
+
+// goalValue returns nil for a goal of 0, which disables the goal.
+func goalValue[T int | float64](goal T) *T {
+	if goal == 0 {
+		return nil
+	}
+	return &goal
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// TestHTTPStatusWhileUpdating reads the status while the state is updated concurrently, like the main loop does. It
+// is meant to be run with -race.
+func TestHTTPStatusWhileUpdating(t *testing.T) {
+	conn := &recordingConnection{}
+	app := newReadyTestApp(t, conn)
+	app.HTTPRateLimit = 1000
+	app.HTTPRateBurst = 1000
+
+	server := httptest.NewServer(app.httpHandler())
+	defer server.Close()
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			app.mu.Lock()
+			app.state.status.Speed = float64(i%60) / 10
+			app.state.stepsAccumTotal = i
+			app.mu.Unlock()
+		}
+	}()
+
+	for i := 0; i < 50; i++ {
+		resp, err := http.Get(server.URL + "/status")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var status statusResponse
+		err = json.NewDecoder(resp.Body).Decode(&status)
+		_ = resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("status code = %d, want %d", resp.StatusCode, http.StatusOK)
+		}
+		if status.ConnectionState != connectionStateReady.String() {
+			t.Errorf("connection state = %q, want %q", status.ConnectionState, connectionStateReady.String())
+		}
+	}
+
+	close(done)
+	wg.Wait()
+}
+
+func TestHTTPPutConfigRejectsTargetSpeedAboveMax(t *testing.T) {
+	configFile = filepath.Join(t.TempDir(), "walkingpad.json")
+	maxSpeed := 4.0
+	app := &App{TargetSpeed: 2.5, MaxSpeed: &maxSpeed, HTTPRateLimit: 1, HTTPRateBurst: 1}
+
+	server := httptest.NewServer(app.httpHandler())
+	defer server.Close()
+
+	req, err := http.NewRequest(http.MethodPut, server.URL+"/config", strings.NewReader(`{"targetSpeed": 5}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("status code = %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+	if app.TargetSpeed != 2.5 {
+		t.Errorf("target speed = %v, want 2.5", app.TargetSpeed)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/binary"
+	"runtime"
+
+	"github.com/getlantern/systray"
+)
+
+// The icons are black on transparent, so that macOS can use them as template icons, which adapt to the menu bar.
+var (
+	//go:embed assets/icons/disconnected.png
+	iconDisconnected []byte
+	//go:embed assets/icons/connected.png
+	iconConnected []byte
+	//go:embed assets/icons/walking.png
+	iconWalking []byte
+)
+
+// updateTitle shows the title in the menu bar. With CompactTitle, an icon that reflects the state is shown instead, and
+// the title is moved to the tooltip and the first menu item.
+func (app *App) updateTitle(title string) {
+	if !app.CompactTitle {
+		systray.SetTitle(title)
+		return
+	}
+
+	icon, name := iconConnected, "connected"
+	switch {
+	case app.state.lostWhileRunning,
+		!app.state.conn.Is(connectionStateConnected) && !app.state.conn.Is(connectionStateReady):
+		icon, name = iconDisconnected, "disconnected"
+	case app.state.started:
+		icon, name = iconWalking, "walking"
+	}
+	// setting the icon is expensive on some platforms, e.g. it is written to a temporary file on Linux
+	if name != app.trayIcon {
+		app.trayIcon = name
+		systray.SetTemplateIcon(icon, platformIcon(icon))
+	}
+
+	systray.SetTooltip(title)
+	app.mTitle.SetTitle(title)
+}
+
+// platformIcon converts a PNG icon into the format expected by the platform. Windows requires ICO files, which may
+// embed a PNG image.
+func platformIcon(png []byte) []byte {
+	if runtime.GOOS != "windows" {
+		return png
+	}
+
+	ico := make([]byte, 0, 22+len(png))
+	ico = binary.LittleEndian.AppendUint16(ico, 0)  // reserved
+	ico = binary.LittleEndian.AppendUint16(ico, 1)  // type: icon
+	ico = binary.LittleEndian.AppendUint16(ico, 1)  // number of images
+	ico = append(ico, 32, 32, 0, 0)                 // width, height, palette size, reserved
+	ico = binary.LittleEndian.AppendUint16(ico, 1)  // color planes
+	ico = binary.LittleEndian.AppendUint16(ico, 32) // bits per pixel
+	ico = binary.LittleEndian.AppendUint32(ico, uint32(len(png)))
+	ico = binary.LittleEndian.AppendUint32(ico, 22) // offset of the image
+	return append(ico, png...)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+)
+
+const (
+	logFileMaxSize = 5 << 20 // 5 MiB
+	// logFileBackups is how many rotated log files are kept next to the current one.
+	logFileBackups = 3
+)
+
+func logFilePath() (string, error) {
+	return configSiblingPath(".log")
+}
+
+// setupLogging configures slog to write to stderr and, if logToFile is set, to a rotating log file next to the config
+// file as well.
+func setupLogging(logToFile bool) error {
+	if !logToFile {
+		return nil
+	}
+
+	path, err := logFilePath()
+	if err != nil {
+		return err
+	}
+	file, err := openRotatingFile(path, logFileMaxSize, logFileBackups)
+	if err != nil {
+		return err
+	}
+
+	handler := slog.NewTextHandler(io.MultiWriter(os.Stderr, file), nil)
+	slog.SetDefault(slog.New(handler))
+	slog.Info("log to file", "path", path)
+	return nil
+}
+
+// rotatingFile is a log file that is rotated once it exceeds maxSize. Rotated files are named path.1 (newest) to
+// path.<backups> (oldest); older ones are deleted.
+type rotatingFile struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+	backups int
+	file    *os.File
+	size    int64
+}
+
+func openRotatingFile(path string, maxSize int64, backups int) (*rotatingFile, error) {
+	f := &rotatingFile{path: path, maxSize: maxSize, backups: backups}
+	err := f.open()
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return fmt.Errorf("failed to stat log file: %w", err)
+	}
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		err := f.rotate()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *rotatingFile) rotate() error {
+	_ = f.file.Close()
+
+	// shift path.1 -> path.2 etc., overwriting the oldest backup
+	for i := f.backups - 1; i >= 1; i-- {
+		_ = os.Rename(fmt.Sprintf("%s.%d", f.path, i), fmt.Sprintf("%s.%d", f.path, i+1))
+	}
+	// if renaming fails, logging continues in the current file rather than not at all
+	_ = os.Rename(f.path, f.path+".1")
+	return f.open()
+}
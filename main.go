@@ -1,11 +1,19 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"log/slog"
+	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/getlantern/systray"
@@ -13,61 +21,497 @@ import (
 )
 
 func main() {
+	fake := flag.Bool("fake", false, "simulate a walking pad instead of connecting via bluetooth")
+	flag.StringVar(&configFile, "config", os.Getenv("WALKINGPAD_CONFIG"), "path of the config file (env WALKINGPAD_CONFIG)")
+	flag.Parse()
+
 	cfg, err := tryLoadConfig()
 	if err != nil {
 		slog.Error("failed to load config", "err", err)
 		cfg = &Config{
 			PreferredDevice:     "",
-			TargetSpeed:         2.5,
-			WebhookURL:          nil,
+			TargetSpeed:         defaultTargetSpeed,
 			WebhookThresholdMin: nil,
+			FitExportDir:        nil,
 		}
 	}
 
+	err = cfg.Validate()
+	if err != nil {
+		slog.Error("invalid config, falling back to defaults for invalid fields", "err", err)
+	}
+
+	err = setupLogging(cfg.LogFile)
+	if err != nil {
+		slog.Error("failed to set up log file", "err", err)
+	}
+
+	httpRateLimit := 2.0
+	if cfg.HTTPRateLimit != nil {
+		httpRateLimit = *cfg.HTTPRateLimit
+	}
+	httpRateBurst := 5
+	if cfg.HTTPRateBurst != nil {
+		httpRateBurst = *cfg.HTTPRateBurst
+	}
+
+	speedStep := 0.5
+	if cfg.SpeedStep != nil {
+		speedStep = *cfg.SpeedStep
+	}
+
 	webhookThreshold := 5 * time.Minute
 	if cfg.WebhookThresholdMin != nil {
 		webhookThreshold = time.Duration(*cfg.WebhookThresholdMin*60.0) * time.Second
 	}
 
+	var sessionIdleSplit time.Duration
+	if cfg.SessionIdleSplitMin != nil {
+		sessionIdleSplit = time.Duration(*cfg.SessionIdleSplitMin*60.0) * time.Second
+	}
+
+	var durationGoal *time.Duration
+	if cfg.DurationGoalMin != nil {
+		goal := time.Duration(*cfg.DurationGoalMin*60.0) * time.Second
+		durationGoal = &goal
+	}
+
+	webhookMaxAttempts := 5
+	if cfg.WebhookMaxAttempts != nil {
+		webhookMaxAttempts = *cfg.WebhookMaxAttempts
+	}
+
+	reconnectBaseDelay := 5 * time.Second
+	if cfg.ReconnectBaseDelaySec != nil {
+		reconnectBaseDelay = time.Duration(*cfg.ReconnectBaseDelaySec * float64(time.Second))
+	}
+	reconnectMaxDelay := 2 * time.Minute
+	if cfg.ReconnectMaxDelaySec != nil {
+		reconnectMaxDelay = time.Duration(*cfg.ReconnectMaxDelaySec * float64(time.Second))
+	}
+
+	scanTimeout := 5 * time.Second
+	if cfg.ScanTimeoutSec != nil {
+		scanTimeout = time.Duration(*cfg.ScanTimeoutSec * float64(time.Second))
+	}
+
+	heartbeatInterval := 30 * time.Second
+	if cfg.HeartbeatIntervalSec != nil {
+		heartbeatInterval = time.Duration(*cfg.HeartbeatIntervalSec * float64(time.Second))
+	}
+
+	staleTimeout := defaultStaleTimeout
+	if cfg.StaleTimeoutSec != nil {
+		staleTimeout = time.Duration(*cfg.StaleTimeoutSec * float64(time.Second))
+	}
+
+	var padOptions WalkingPadOptions
+	if cfg.StatsIntervalSec != nil {
+		padOptions.StatsInterval = time.Duration(*cfg.StatsIntervalSec * float64(time.Second))
+	}
+	if cfg.CommandDelayMs != nil {
+		padOptions.CommandDelay = time.Duration(*cfg.CommandDelayMs) * time.Millisecond
+	}
+	padOptions.LogRawFrames = cfg.LogRawFrames
+
+	var programs []IntervalProgram
+	for _, pc := range cfg.Programs {
+		program := IntervalProgram{Name: pc.Name, Repeat: pc.Repeat}
+		for _, step := range pc.Steps {
+			program.Steps = append(program.Steps, IntervalStep{
+				Speed:    step.Speed,
+				Duration: time.Duration(step.DurationMin*60.0) * time.Second,
+			})
+		}
+		programs = append(programs, program)
+	}
+
 	app := &App{
-		Adapter:          bluetooth.DefaultAdapter,
-		PreferredDevice:  cfg.PreferredDevice,
-		TargetSpeed:      cfg.TargetSpeed,
-		WebhookURL:       cfg.WebhookURL,
-		WebhookThreshold: webhookThreshold,
+		Adapter:                 newBluetoothAdapter(bluetooth.DefaultAdapter),
+		PreferredDevice:         cfg.PreferredDevice,
+		TargetSpeed:             cfg.TargetSpeed,
+		WebhookURLs:             cfg.WebhookURL,
+		SessionStartWebhookURLs: cfg.SessionStartWebhookURL,
+		HeartbeatWebhookURLs:    cfg.HeartbeatWebhookURL,
+		HeartbeatInterval:       heartbeatInterval,
+		WebhookMethod:           cfg.WebhookMethod,
+		WebhookHeaders:          cfg.WebhookHeaders,
+		WebhookThreshold:        webhookThreshold,
+		FitExportDir:            cfg.FitExportDir,
+		HealthExportDir:         cfg.HealthExportDir,
+		MovingSpeedThreshold:    cfg.MovingSpeedThreshold,
+		HTTPListenAddr:          cfg.HTTPListenAddr,
+		StandbySpeedAction:      cfg.StandbySpeedAction,
+		HTTPRateLimit:           httpRateLimit,
+		HTTPRateBurst:           httpRateBurst,
+		MaxDeviceSpeed:          cfg.MaxDeviceSpeed,
+		SpeedStep:               speedStep,
+		MaxSpeed:                cfg.MaxSpeed,
+		Units:                   cfg.Units,
+		BodyWeightKg:            cfg.BodyWeightKg,
+		NotifyStandby:           cfg.NotifyStandby,
+		NotifyPadErrors:         cfg.NotifyPadErrors,
+		SessionIdleSplit:        sessionIdleSplit,
+		StepGoal:                cfg.StepGoal,
+		DurationGoal:            durationGoal,
+		DistanceGoalKm:          cfg.DistanceGoalKm,
+		MetricsAddr:             cfg.MetricsAddr,
+		ControlSocket:           cfg.ControlSocket,
+		HeartRateDevice:         cfg.HeartRateDevice,
+		CompactTitle:            cfg.CompactTitle,
+		WebhookMaxAttempts:      webhookMaxAttempts,
+		Programs:                programs,
+		ReconnectBaseDelay:      reconnectBaseDelay,
+		ReconnectMaxDelay:       reconnectMaxDelay,
+		StaleTimeout:            staleTimeout,
+		ScanTimeout:             scanTimeout,
+		StartRamp:               cfg.StartRampEnabled,
+		StopRamp:                cfg.StopRampEnabled,
+		KeepRunningOnQuit:       cfg.KeepRunningOnQuit,
+		AutoPauseIdle:           time.Duration(cfg.AutoPauseIdleSec * float64(time.Second)),
+		StravaAccessToken:       cfg.StravaAccessToken,
+		PadOptions:              padOptions,
+		AutoSavePreferred:       cfg.AutoSavePreferred,
+	}
+	if *fake || cfg.Simulate {
+		slog.Info("simulate walking pad")
+		app.Adapter = newFakeAdapter(nil)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if flag.NArg() > 0 {
+		err := app.runCLI(ctx, flag.Args())
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
 	}
+
+	if cfg.MQTT != nil {
+		go app.publishMQTT(*cfg.MQTT, app.Subscribe())
+	}
+	// quitting the systray closes the app, which stops the belt
+	go func() {
+		<-ctx.Done()
+		slog.Info("received signal, quitting")
+		systray.Quit()
+	}()
 	systray.Run(app.Init, app.Close)
 }
 
 type Config struct {
-	PreferredDevice     string   `json:"preferredDevice"`
-	TargetSpeed         float64  `json:"targetSpeed"`
-	WebhookURL          *string  `json:"webhookURL"`
-	WebhookThresholdMin *float64 `json:"webhookThresholdMin"`
+	PreferredDevice        string             `json:"preferredDevice"`
+	TargetSpeed            float64            `json:"targetSpeed"`
+	WebhookURL             WebhookURLs        `json:"webhookURL"`
+	SessionStartWebhookURL WebhookURLs        `json:"sessionStartWebhookURL"`
+	HeartbeatWebhookURL    WebhookURLs        `json:"heartbeatWebhookURL"`
+	HeartbeatIntervalSec   *float64           `json:"heartbeatIntervalSec"`
+	WebhookMethod          string             `json:"webhookMethod"`
+	WebhookHeaders         map[string]string  `json:"webhookHeaders"`
+	WebhookThresholdMin    *float64           `json:"webhookThresholdMin"`
+	FitExportDir           *string            `json:"fitExportDir"`
+	MovingSpeedThreshold   float64            `json:"movingSpeedThreshold"`
+	HTTPListenAddr         *string            `json:"httpListenAddr"`
+	StandbySpeedAction     StandbySpeedAction `json:"standbySpeedAction"`
+	HTTPRateLimit          *float64           `json:"httpRateLimit"`
+	HTTPRateBurst          *int               `json:"httpRateBurst"`
+	MaxDeviceSpeed         *float64           `json:"maxDeviceSpeed"`
+	SpeedStep              *float64           `json:"speedStep"`
+	MaxSpeed               *float64           `json:"maxSpeed"`
+	Units                  Units              `json:"units"`
+	SessionIdleSplitMin    *float64           `json:"sessionIdleSplitMin"`
+	StepGoal               *int               `json:"stepGoal"`
+	DurationGoalMin        *float64           `json:"durationGoalMin"`
+	DistanceGoalKm         *float64           `json:"distanceGoalKm"`
+	MetricsAddr            *string            `json:"metricsAddr"`
+	ControlSocket          *string            `json:"controlSocket"`
+	LogFile                bool               `json:"logFile"`
+	WebhookMaxAttempts     *int               `json:"webhookMaxAttempts"`
+	Programs               []ProgramConfig    `json:"programs"`
+	Simulate               bool               `json:"simulate"`
+	ReconnectBaseDelaySec  *float64           `json:"reconnectBaseDelaySec"`
+	ReconnectMaxDelaySec   *float64           `json:"reconnectMaxDelaySec"`
+	StaleTimeoutSec        *float64           `json:"staleTimeoutSec"`
+	ScanTimeoutSec         *float64           `json:"scanTimeoutSec"`
+	StravaAccessToken      *string            `json:"stravaAccessToken"`
+	MQTT                   *MQTTConfig        `json:"mqtt"`
+	HealthExportDir        *string            `json:"healthExportDir"`
+	BodyWeightKg           *float64           `json:"bodyWeightKg"`
+	NotifyStandby          bool               `json:"notifyStandby"`
+	NotifyPadErrors        bool               `json:"notifyPadErrors"`
+	StatsIntervalSec       *float64           `json:"statsIntervalSec"`
+	CommandDelayMs         *int               `json:"commandDelayMs"`
+	LogRawFrames           bool               `json:"logRawFrames"`
+	HeartRateDevice        *string            `json:"heartRateDevice"`
+	CompactTitle           bool               `json:"compactTitle"`
+	AutoSavePreferred      bool               `json:"autoSavePreferred"`
+	AutoPauseIdleSec       float64            `json:"autoPauseIdleSec"`
+	StartRampEnabled       bool               `json:"startRampEnabled"`
+	StopRampEnabled        bool               `json:"stopRampEnabled"`
+	KeepRunningOnQuit      bool               `json:"keepRunningOnQuit"`
 }
 
-func tryLoadConfig() (*Config, error) {
-	configDir, err := os.UserConfigDir()
+const defaultTargetSpeed = 2.5
+
+const defaultStaleTimeout = 15 * time.Second
+
+var speedStepChoices = []float64{0.1, 0.25, 0.5}
+
+// Validate checks the ranges of the config values. Invalid values are reset to their defaults, so that the rest of the
+// config can still be used. The returned error describes all invalid values.
+func (cfg *Config) Validate() error {
+	var errs []error
+	invalid := func(field string, value any, reason string) {
+		errs = append(errs, fmt.Errorf("%s: %v %s", field, value, reason))
+	}
+
+	if cfg.MaxDeviceSpeed != nil && (*cfg.MaxDeviceSpeed <= 0 || *cfg.MaxDeviceSpeed > maxEncodableSpeed) {
+		invalid("maxDeviceSpeed", *cfg.MaxDeviceSpeed, fmt.Sprintf("must be within (0, %.1f]", maxEncodableSpeed))
+		cfg.MaxDeviceSpeed = nil
+	}
+	maxDeviceSpeed := defaultWalkingPadProfile.MaxSpeed
+	if cfg.MaxDeviceSpeed != nil {
+		maxDeviceSpeed = *cfg.MaxDeviceSpeed
+	}
+
+	// the protocol sends speeds as speed*10, so every step has to be a multiple of 0.1 km/h
+	if cfg.SpeedStep != nil && !slices.Contains(speedStepChoices, *cfg.SpeedStep) {
+		invalid("speedStep", *cfg.SpeedStep, fmt.Sprintf("must be one of %v", speedStepChoices))
+		cfg.SpeedStep = nil
+	}
+
+	if cfg.CommandDelayMs != nil && time.Duration(*cfg.CommandDelayMs)*time.Millisecond < minCommandDelay {
+		invalid("commandDelayMs", *cfg.CommandDelayMs, fmt.Sprintf("must be at least %d", minCommandDelay.Milliseconds()))
+		cfg.CommandDelayMs = nil
+	}
+
+	if cfg.TargetSpeed <= 0 || cfg.TargetSpeed > maxDeviceSpeed {
+		invalid("targetSpeed", cfg.TargetSpeed, fmt.Sprintf("must be within (0, %.1f]", maxDeviceSpeed))
+		cfg.TargetSpeed = defaultTargetSpeed
+	}
+	// the pad rejects a max speed above its own, and the target speed would be clamped right away
+	if cfg.MaxSpeed != nil && (*cfg.MaxSpeed < cfg.TargetSpeed || *cfg.MaxSpeed > maxDeviceSpeed) {
+		invalid("maxSpeed", *cfg.MaxSpeed, fmt.Sprintf("must be within [targetSpeed %.1f, %.1f]", cfg.TargetSpeed,
+			maxDeviceSpeed))
+		cfg.MaxSpeed = nil
+	}
+
+	validURLs := func(field string, urls WebhookURLs) WebhookURLs {
+		var valid WebhookURLs
+		for _, rawURL := range urls {
+			u, err := url.Parse(rawURL)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				invalid(field, rawURL, "is not a valid http(s) URL")
+				continue
+			}
+			valid = append(valid, rawURL)
+		}
+		return valid
+	}
+	cfg.WebhookURL = validURLs("webhookURL", cfg.WebhookURL)
+	cfg.SessionStartWebhookURL = validURLs("sessionStartWebhookURL", cfg.SessionStartWebhookURL)
+	cfg.HeartbeatWebhookURL = validURLs("heartbeatWebhookURL", cfg.HeartbeatWebhookURL)
+	if cfg.HeartbeatIntervalSec != nil && *cfg.HeartbeatIntervalSec <= 0 {
+		invalid("heartbeatIntervalSec", *cfg.HeartbeatIntervalSec, "must be positive")
+		cfg.HeartbeatIntervalSec = nil
+	}
+
+	if cfg.WebhookThresholdMin != nil && *cfg.WebhookThresholdMin < 0 {
+		invalid("webhookThresholdMin", *cfg.WebhookThresholdMin, "must not be negative")
+		cfg.WebhookThresholdMin = nil
+	}
+	if cfg.WebhookMaxAttempts != nil && *cfg.WebhookMaxAttempts < 1 {
+		invalid("webhookMaxAttempts", *cfg.WebhookMaxAttempts, "must be at least 1")
+		cfg.WebhookMaxAttempts = nil
+	}
+	if cfg.HTTPRateLimit != nil && *cfg.HTTPRateLimit <= 0 {
+		invalid("httpRateLimit", *cfg.HTTPRateLimit, "must be positive")
+		cfg.HTTPRateLimit = nil
+	}
+	if cfg.HTTPRateBurst != nil && *cfg.HTTPRateBurst < 1 {
+		invalid("httpRateBurst", *cfg.HTTPRateBurst, "must be at least 1")
+		cfg.HTTPRateBurst = nil
+	}
+	if cfg.Units != "" && cfg.Units != UnitsMetric && cfg.Units != UnitsImperial {
+		invalid("units", cfg.Units, fmt.Sprintf("must be %q or %q", UnitsMetric, UnitsImperial))
+		cfg.Units = ""
+	}
+	if cfg.SessionIdleSplitMin != nil && *cfg.SessionIdleSplitMin < 0 {
+		invalid("sessionIdleSplitMin", *cfg.SessionIdleSplitMin, "must not be negative")
+		cfg.SessionIdleSplitMin = nil
+	}
+	if cfg.StepGoal != nil && *cfg.StepGoal <= 0 {
+		invalid("stepGoal", *cfg.StepGoal, "must be positive")
+		cfg.StepGoal = nil
+	}
+	if cfg.DurationGoalMin != nil && *cfg.DurationGoalMin <= 0 {
+		invalid("durationGoalMin", *cfg.DurationGoalMin, "must be positive")
+		cfg.DurationGoalMin = nil
+	}
+	if cfg.DistanceGoalKm != nil && *cfg.DistanceGoalKm <= 0 {
+		invalid("distanceGoalKm", *cfg.DistanceGoalKm, "must be positive")
+		cfg.DistanceGoalKm = nil
+	}
+	if cfg.ReconnectBaseDelaySec != nil && *cfg.ReconnectBaseDelaySec <= 0 {
+		invalid("reconnectBaseDelaySec", *cfg.ReconnectBaseDelaySec, "must be positive")
+		cfg.ReconnectBaseDelaySec = nil
+	}
+	if cfg.ReconnectMaxDelaySec != nil && *cfg.ReconnectMaxDelaySec <= 0 {
+		invalid("reconnectMaxDelaySec", *cfg.ReconnectMaxDelaySec, "must be positive")
+		cfg.ReconnectMaxDelaySec = nil
+	}
+	if cfg.ScanTimeoutSec != nil && *cfg.ScanTimeoutSec <= 0 {
+		invalid("scanTimeoutSec", *cfg.ScanTimeoutSec, "must be positive")
+		cfg.ScanTimeoutSec = nil
+	}
+	if cfg.StaleTimeoutSec != nil && *cfg.StaleTimeoutSec <= 0 {
+		invalid("staleTimeoutSec", *cfg.StaleTimeoutSec, "must be positive")
+		cfg.StaleTimeoutSec = nil
+	}
+	// the pad only sends updates when asked for the stats, so a stale timeout below the interval would reconnect all
+	// the time
+	staleTimeout := defaultStaleTimeout
+	if cfg.StaleTimeoutSec != nil {
+		staleTimeout = time.Duration(*cfg.StaleTimeoutSec * float64(time.Second))
+	}
+	if cfg.StatsIntervalSec != nil {
+		statsInterval := max(time.Duration(*cfg.StatsIntervalSec*float64(time.Second)), minStatsInterval)
+		if statsInterval >= staleTimeout {
+			reason := fmt.Sprintf("must be less than staleTimeoutSec (%v)", staleTimeout.Seconds())
+			invalid("statsIntervalSec", *cfg.StatsIntervalSec, reason)
+			cfg.StatsIntervalSec = nil
+		}
+	}
+	if cfg.StaleTimeoutSec != nil && cfg.StatsIntervalSec == nil && defaultStatsInterval >= staleTimeout {
+		reason := fmt.Sprintf("must be greater than statsIntervalSec (%v)", defaultStatsInterval.Seconds())
+		invalid("staleTimeoutSec", *cfg.StaleTimeoutSec, reason)
+		cfg.StaleTimeoutSec = nil
+	}
+	if cfg.AutoPauseIdleSec < 0 {
+		invalid("autoPauseIdleSec", cfg.AutoPauseIdleSec, "must not be negative")
+		cfg.AutoPauseIdleSec = 0
+	}
+	if cfg.BodyWeightKg != nil && *cfg.BodyWeightKg <= 0 {
+		invalid("bodyWeightKg", *cfg.BodyWeightKg, "must be positive")
+		cfg.BodyWeightKg = nil
+	}
+
+	return errors.Join(errs...)
+}
+
+type ProgramConfig struct {
+	Name   string              `json:"name"`
+	Repeat int                 `json:"repeat"`
+	Steps  []ProgramStepConfig `json:"steps"`
+}
+
+type ProgramStepConfig struct {
+	Speed       float64 `json:"speed"`
+	DurationMin float64 `json:"durationMin"`
+}
+
+// configFile overrides the path of the config file. If empty, walkingpad.json in the user config dir is used.
+var configFile string
+
+// configSiblingPath returns the path of a data file next to the config file. Its name is derived from the name of the
+// config file, e.g. walkingpad_sessions.jsonl for walkingpad.json, so that several configs can share a dir.
+func configSiblingPath(suffix string) (string, error) {
+	path, err := configPath()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get user config dir: %w", err)
+		return "", err
 	}
+	return strings.TrimSuffix(path, filepath.Ext(path)) + suffix, nil
+}
 
-	configPath := filepath.Join(configDir, "walkingpad.json")
-	slog.Info("configPath", "path", configPath)
+func tryLoadConfig() (*Config, error) {
+	path, err := configPath()
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("configPath", "path", path)
 
-	configFile, err := os.Open(configPath)
+	f, err := os.Open(path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open config file: %w", err)
 	}
-	defer func() { _ = configFile.Close() }()
+	defer func() { _ = f.Close() }()
 
 	config := &Config{}
-	err = json.NewDecoder(configFile).Decode(config)
+	err = json.NewDecoder(f).Decode(config)
 	if err != nil {
 		return nil, fmt.Errorf("failed to decode config file: %w", err)
 	}
 
-	slog.Info("loaded config", "config", config)
+	// the config itself is not logged, as it contains secrets like webhook headers, webhook URLs, and tokens
+	slog.Info("loaded config", "path", path)
 
 	return config, nil
 }
+
+func configPath() (string, error) {
+	if configFile != "" {
+		return configFile, nil
+	}
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get user config dir: %w", err)
+	}
+	return filepath.Join(configDir, "walkingpad.json"), nil
+}
+
+// updateConfig sets the given fields in the config file, keeping all other fields as they are. The file is created if
+// it does not exist and replaced atomically, so that it is never left half-written.
+func updateConfig(fields map[string]any) error {
+	path, err := configPath()
+	if err != nil {
+		return err
+	}
+
+	raw := make(map[string]json.RawMessage)
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+	if len(data) != 0 {
+		err = json.Unmarshal(data, &raw)
+		if err != nil {
+			return fmt.Errorf("failed to decode config file: %w", err)
+		}
+	}
+
+	for key, value := range fields {
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return fmt.Errorf("failed to encode config field %s: %w", key, err)
+		}
+		raw[key] = encoded
+	}
+
+	data, err = json.MarshalIndent(raw, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode config file: %w", err)
+	}
+
+	tmpFile, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp config file: %w", err)
+	}
+	defer func() { _ = os.Remove(tmpFile.Name()) }()
+
+	_, err = tmpFile.Write(append(data, '\n'))
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write temp config file: %w", err)
+	}
+
+	err = os.Rename(tmpFile.Name(), path)
+	if err != nil {
+		return fmt.Errorf("failed to replace config file: %w", err)
+	}
+	return nil
+}
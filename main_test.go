@@ -0,0 +1,108 @@
+package main
+
+import "testing"
+
+func TestValidateStatsInterval(t *testing.T) {
+	float := func(v float64) *float64 { return &v }
+
+	tests := []struct {
+		name             string
+		statsIntervalSec *float64
+		staleTimeoutSec  *float64
+		wantErr          bool
+		wantStats        *float64
+		wantStale        *float64
+	}{
+		{name: "defaults"},
+		{
+			name:             "interval below timeout",
+			statsIntervalSec: float(5),
+			staleTimeoutSec:  float(10),
+			wantStats:        float(5),
+			wantStale:        float(10),
+		},
+		{
+			name:             "interval equals timeout",
+			statsIntervalSec: float(10),
+			staleTimeoutSec:  float(10),
+			wantErr:          true,
+			wantStale:        float(10),
+		},
+		{name: "interval above default timeout", statsIntervalSec: float(20), wantErr: true},
+		{name: "timeout below default interval", staleTimeoutSec: float(2), wantErr: true},
+		{name: "both invalid", statsIntervalSec: float(5), staleTimeoutSec: float(2), wantErr: true},
+		{name: "interval below minimum", statsIntervalSec: float(0.5), staleTimeoutSec: float(1), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{
+				TargetSpeed:      defaultTargetSpeed,
+				StatsIntervalSec: tt.statsIntervalSec,
+				StaleTimeoutSec:  tt.staleTimeoutSec,
+			}
+			err := cfg.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("expected error: %v, got %v", tt.wantErr, err)
+			}
+			if !equalPtr(cfg.StatsIntervalSec, tt.wantStats) {
+				t.Errorf("expected statsIntervalSec %v, got %v", fmtPtr(tt.wantStats), fmtPtr(cfg.StatsIntervalSec))
+			}
+			if !equalPtr(cfg.StaleTimeoutSec, tt.wantStale) {
+				t.Errorf("expected staleTimeoutSec %v, got %v", fmtPtr(tt.wantStale), fmtPtr(cfg.StaleTimeoutSec))
+			}
+		})
+	}
+}
+
+func equalPtr[T comparable](a, b *T) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func fmtPtr[T any](v *T) any {
+	if v == nil {
+		return nil
+	}
+	return *v
+}
+
+func TestValidateMaxSpeed(t *testing.T) {
+	float := func(v float64) *float64 { return &v }
+
+	tests := []struct {
+		name           string
+		maxSpeed       *float64
+		maxDeviceSpeed *float64
+		wantErr        bool
+		want           *float64
+	}{
+		{name: "unset"},
+		{name: "within range", maxSpeed: float(4), want: float(4)},
+		{name: "equals target speed", maxSpeed: float(defaultTargetSpeed), want: float(defaultTargetSpeed)},
+		{name: "equals device max", maxSpeed: float(6), want: float(6)},
+		{name: "below target speed", maxSpeed: float(2), wantErr: true},
+		{name: "above device max", maxSpeed: float(7), wantErr: true},
+		{name: "within raised device max", maxSpeed: float(7), maxDeviceSpeed: float(8), want: float(7)},
+		{name: "not positive", maxSpeed: float(0), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{
+				TargetSpeed:    defaultTargetSpeed,
+				MaxSpeed:       tt.maxSpeed,
+				MaxDeviceSpeed: tt.maxDeviceSpeed,
+			}
+			err := cfg.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error: %v, got %v", tt.wantErr, err)
+			}
+			if !equalPtr(cfg.MaxSpeed, tt.want) {
+				t.Errorf("maxSpeed = %s, want %s", fmtPtr(cfg.MaxSpeed), fmtPtr(tt.want))
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// serveMetrics exposes the stats of the current session in the Prometheus text format.
+// See: https://prometheus.io/docs/instrumenting/exposition_formats/
+func (app *App) serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /metrics", app.handleMetrics)
+
+	slog.Info("start metrics server", "addr", addr)
+
+	err := http.ListenAndServe(addr, mux)
+	if err != nil {
+		slog.Error("serveMetrics", "err", err)
+	}
+}
+
+func writeGauge(w io.Writer, name, help string, value float64) {
+	_, _ = fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %g\n", name, help, name, name, value)
+}
+
+// metricsSnapshot holds the values of all metrics, so that they can be written without holding the lock of the app.
+type metricsSnapshot struct {
+	conn        connectionState
+	started     float64
+	status      WalkingPadStatus
+	targetSpeed float64
+	km          float64
+	steps       int
+	duration    time.Duration
+}
+
+func (app *App) metricsSnapshot() metricsSnapshot {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+
+	snapshot := metricsSnapshot{
+		conn:        app.state.conn.Current(),
+		status:      app.state.status,
+		targetSpeed: app.TargetSpeed,
+		km:          app.state.kmAccumTotal,
+		steps:       app.state.stepsAccumTotal,
+		duration:    app.state.timeAccumTotal,
+	}
+	if app.state.started {
+		snapshot.started = 1
+	}
+	return snapshot
+}
+
+func (app *App) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	m := app.metricsSnapshot()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	writeGauge(w, "walkingpad_connection_state", "Connection state (0=disconnected, 1=scanning, 2=connecting, 3=connected, 4=ready).", float64(m.conn))
+	writeGauge(w, "walkingpad_started", "Whether the belt is running.", m.started)
+	writeGauge(w, "walkingpad_mode", "Mode of the belt (0=auto, 1=manual, 2=standby).", float64(m.status.Mode))
+	writeGauge(w, "walkingpad_speed_kmh", "Current speed of the belt in km/h.", m.status.Speed)
+	writeGauge(w, "walkingpad_target_speed_kmh", "Target speed of the belt in km/h.", m.targetSpeed)
+	writeGauge(w, "walkingpad_session_distance_km", "Distance walked in the current session in km.", m.km)
+	writeGauge(w, "walkingpad_session_steps", "Steps taken in the current session.", float64(m.steps))
+	writeGauge(w, "walkingpad_session_duration_seconds", "Walking time of the current session in seconds.", m.duration.Seconds())
+}
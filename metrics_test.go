@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// TestMetricsWhileUpdating collects the metrics while the state is updated concurrently, like the main loop does. It
+// is meant to be run with -race.
+func TestMetricsWhileUpdating(t *testing.T) {
+	conn := &recordingConnection{}
+	app := newReadyTestApp(t, conn)
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			app.mu.Lock()
+			app.state.status.Speed = float64(i%60) / 10
+			app.state.stepsAccumTotal = i
+			app.mu.Unlock()
+		}
+	}()
+
+	for i := 0; i < 50; i++ {
+		w := httptest.NewRecorder()
+		app.handleMetrics(w, httptest.NewRequest("GET", "/metrics", nil))
+		if !strings.Contains(w.Body.String(), "walkingpad_connection_state 4\n") {
+			t.Fatalf("unexpected metrics:\n%s", w.Body.String())
+		}
+	}
+
+	close(done)
+	wg.Wait()
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"time"
+)
+
+// The app publishes its status to an MQTT broker using a minimal MQTT 3.1.1 client, which only supports publishing
+// with QoS 0. Home Assistant discovers the published sensors via its MQTT discovery.
+// See: https://docs.oasis-open.org/mqtt/mqtt/v3.1.1/mqtt-v3.1.1.html
+// See: https://www.home-assistant.io/integrations/mqtt/#mqtt-discovery
+
+const (
+	mqttKeepAlive      = 60 * time.Second
+	mqttDialTimeout    = 5 * time.Second
+	mqttRetryDelay     = 30 * time.Second
+	mqttDiscoveryTopic = "homeassistant"
+
+	mqttPacketConnect    = 0x10
+	mqttPacketConnack    = 0x20
+	mqttPacketPublish    = 0x30
+	mqttPacketPingreq    = 0xC0
+	mqttPacketDisconnect = 0xE0
+)
+
+type MQTTConfig struct {
+	// Broker is the address of the broker, e.g. localhost:1883.
+	Broker string `json:"broker"`
+	// TopicPrefix is prepended to all topics. Defaults to walkingpad.
+	TopicPrefix string  `json:"topicPrefix"`
+	ClientID    string  `json:"clientID"`
+	Username    *string `json:"username"`
+	Password    *string `json:"password"`
+}
+
+type mqttClient struct {
+	conn net.Conn
+}
+
+func dialMQTT(cfg MQTTConfig) (*mqttClient, error) {
+	conn, err := net.DialTimeout("tcp", cfg.Broker, mqttDialTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("dial: %w", err)
+	}
+
+	var flags byte = 0x02 // clean session
+	payload := mqttString(cfg.ClientID)
+	if cfg.Username != nil {
+		flags |= 0x80
+		payload = append(payload, mqttString(*cfg.Username)...)
+	}
+	if cfg.Password != nil {
+		flags |= 0x40
+		payload = append(payload, mqttString(*cfg.Password)...)
+	}
+
+	var packet []byte
+	packet = append(packet, mqttString("MQTT")...)
+	packet = append(packet, 4, flags) // protocol level 3.1.1
+	packet = binary.BigEndian.AppendUint16(packet, uint16(mqttKeepAlive.Seconds()))
+	packet = append(packet, payload...)
+
+	client := &mqttClient{conn: conn}
+	err = client.write(mqttPacketConnect, packet)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(mqttDialTimeout))
+	connack := make([]byte, 4)
+	_, err = io.ReadFull(conn, connack)
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("read connack: %w", err)
+	}
+	if connack[0] != mqttPacketConnack || connack[3] != 0 {
+		_ = conn.Close()
+		return nil, fmt.Errorf("connection refused: return code %d", connack[3])
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+
+	// incoming packets, i.e. ping responses, are not needed and only drained
+	go func() { _, _ = io.Copy(io.Discard, bufio.NewReader(conn)) }()
+
+	return client, nil
+}
+
+func mqttString(s string) []byte {
+	return append(binary.BigEndian.AppendUint16(nil, uint16(len(s))), s...)
+}
+
+func (c *mqttClient) write(header byte, body []byte) error {
+	var buf bytes.Buffer
+	buf.WriteByte(header)
+
+	// the remaining length is encoded in 7 bit groups, with the highest bit marking continuation
+	length := len(body)
+	for {
+		b := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			b |= 0x80
+		}
+		buf.WriteByte(b)
+		if length == 0 {
+			break
+		}
+	}
+	buf.Write(body)
+
+	_ = c.conn.SetWriteDeadline(time.Now().Add(mqttDialTimeout))
+	_, err := c.conn.Write(buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+	return nil
+}
+
+// publish sends the payload with QoS 0.
+func (c *mqttClient) publish(topic string, payload []byte, retain bool) error {
+	header := byte(mqttPacketPublish)
+	if retain {
+		header |= 0x01
+	}
+	return c.write(header, append(mqttString(topic), payload...))
+}
+
+func (c *mqttClient) ping() error {
+	return c.write(mqttPacketPingreq, nil)
+}
+
+func (c *mqttClient) close() {
+	_ = c.write(mqttPacketDisconnect, nil)
+	_ = c.conn.Close()
+}
+
+// mqttSensor is a sensor announced to Home Assistant, which reads its value from the status topic.
+type mqttSensor struct {
+	key         string
+	name        string
+	unit        string
+	deviceClass string
+}
+
+var mqttSensors = []mqttSensor{
+	{key: "connection_state", name: "Connection state"},
+	{key: "mode", name: "Mode"},
+	{key: "speed", name: "Speed", unit: "km/h", deviceClass: "speed"},
+	{key: "distance_km", name: "Distance", unit: "km", deviceClass: "distance"},
+	{key: "steps", name: "Steps", unit: "steps"},
+	{key: "duration_min", name: "Duration", unit: "min", deviceClass: "duration"},
+}
+
+// publishMQTT publishes the status of the app on every event until the events channel is closed. If the broker cannot
+// be reached, events are dropped and connecting is retried with the next event after mqttRetryDelay.
+func (app *App) publishMQTT(cfg MQTTConfig, events <-chan Event) {
+	if cfg.TopicPrefix == "" {
+		cfg.TopicPrefix = "walkingpad"
+	}
+	if cfg.ClientID == "" {
+		cfg.ClientID = cfg.TopicPrefix
+	}
+	stateTopic := cfg.TopicPrefix + "/status"
+
+	var (
+		client      *mqttClient
+		lastAttempt time.Time
+	)
+	connect := func() {
+		if client != nil || time.Since(lastAttempt) < mqttRetryDelay {
+			return
+		}
+		lastAttempt = time.Now()
+
+		var err error
+		client, err = dialMQTT(cfg)
+		if err != nil {
+			slog.Error("connect mqtt broker", "broker", cfg.Broker, "err", err)
+			return
+		}
+		slog.Info("connected mqtt broker", "broker", cfg.Broker)
+
+		err = app.publishMQTTDiscovery(client, cfg, stateTopic)
+		if err != nil {
+			slog.Error("publishMQTTDiscovery", "err", err)
+			client.close()
+			client = nil
+		}
+	}
+
+	keepAlive := time.NewTicker(mqttKeepAlive / 2)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case _, ok := <-events:
+			if !ok {
+				if client != nil {
+					client.close()
+				}
+				return
+			}
+
+			connect()
+			if client == nil {
+				continue
+			}
+
+			app.mu.Lock()
+			status := app.statusResponse()
+			app.mu.Unlock()
+
+			payload, err := json.Marshal(status)
+			if err != nil {
+				slog.Error("marshal mqtt status", "err", err)
+				continue
+			}
+			err = client.publish(stateTopic, payload, true)
+			if err != nil {
+				slog.Error("publish mqtt status", "err", err)
+				client.close()
+				client = nil
+			}
+		case <-keepAlive.C:
+			if client == nil {
+				continue
+			}
+			err := client.ping()
+			if err != nil {
+				slog.Error("ping mqtt broker", "err", err)
+				client.close()
+				client = nil
+			}
+		}
+	}
+}
+
+// publishMQTTDiscovery announces all sensors to Home Assistant.
+func (app *App) publishMQTTDiscovery(client *mqttClient, cfg MQTTConfig, stateTopic string) error {
+	device := map[string]any{
+		"identifiers":  []string{cfg.ClientID},
+		"name":         "WalkingPad",
+		"manufacturer": "Kingsmith",
+	}
+	for _, sensor := range mqttSensors {
+		config := map[string]any{
+			"name":           sensor.name,
+			"unique_id":      fmt.Sprintf("%s_%s", cfg.ClientID, sensor.key),
+			"state_topic":    stateTopic,
+			"value_template": fmt.Sprintf("{{ value_json.%s }}", sensor.key),
+			"device":         device,
+		}
+		if sensor.unit != "" {
+			config["unit_of_measurement"] = sensor.unit
+		}
+		if sensor.deviceClass != "" {
+			config["device_class"] = sensor.deviceClass
+		}
+
+		payload, err := json.Marshal(config)
+		if err != nil {
+			return fmt.Errorf("marshal discovery config: %w", err)
+		}
+		topic := fmt.Sprintf("%s/sensor/%s_%s/config", mqttDiscoveryTopic, cfg.ClientID, sensor.key)
+		err = client.publish(topic, payload, true)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
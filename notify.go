@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strconv"
+)
+
+// notify shows a desktop notification using the tools of the operating system.
+func notify(title, message string) error {
+	var cmd string
+	var args []string
+
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = "osascript"
+		args = []string{"-e", fmt.Sprintf("display notification %s with title %s", strconv.Quote(message), strconv.Quote(title))}
+	case "windows":
+		cmd = "powershell"
+		args = []string{"-NoProfile", "-Command", fmt.Sprintf(
+			`[reflection.assembly]::loadwithpartialname('System.Windows.Forms') | Out-Null; `+
+				`$n = New-Object System.Windows.Forms.NotifyIcon; $n.Icon = [System.Drawing.SystemIcons]::Information; `+
+				`$n.Visible = $true; $n.ShowBalloonTip(5000, '%s', '%s', 'Info')`,
+			title, message,
+		)}
+	default: // "linux", "freebsd", "openbsd", "netbsd"
+		cmd = "notify-send"
+		args = []string{title, message}
+	}
+	return exec.Command(cmd, args...).Start()
+}
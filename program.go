@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/getlantern/systray"
+)
+
+type IntervalStep struct {
+	Speed    float64
+	Duration time.Duration
+}
+
+// IntervalProgram alternates between speeds automatically. All steps are run Repeat times, after which the belt is
+// stopped.
+type IntervalProgram struct {
+	Name   string
+	Steps  []IntervalStep
+	Repeat int
+}
+
+func (app *App) setupProgramsUI() {
+	if len(app.Programs) == 0 {
+		return
+	}
+
+	app.mPrograms = systray.AddMenuItem("Programs", "")
+	for _, program := range app.Programs {
+		item := app.mPrograms.AddSubMenuItem(program.Name, "")
+		item.ClickedCh = make(chan struct{})
+		go func() {
+			for {
+				<-item.ClickedCh
+				app.mu.Lock()
+				app.runProgram(program)
+				app.mu.Unlock()
+			}
+		}()
+	}
+}
+
+// runProgram runs the program in the background, starting the belt if necessary. The program is cancelled like a
+// ramp, i.e. by any manual speed change, pause, or stop. It must be called with mu held, which the background
+// goroutine takes for every step.
+func (app *App) runProgram(program IntervalProgram) {
+	if !app.state.conn.Is(connectionStateReady) {
+		return
+	}
+
+	app.cancelRamp()
+	ctx, cancel := context.WithCancel(context.Background())
+	app.rampCancel = cancel
+
+	if !app.state.started {
+		err := app.startBelt()
+		if err != nil {
+			slog.Error("startBelt", "err", err)
+			return
+		}
+		app.updateUI()
+	}
+
+	slog.Info("start program", "name", program.Name)
+
+	go func() {
+		for i := 0; i < max(1, program.Repeat); i++ {
+			for _, step := range program.Steps {
+				app.mu.Lock()
+				// the program might have been cancelled while waiting for the lock
+				if ctx.Err() != nil || !app.state.conn.Is(connectionStateReady) || !app.state.started {
+					app.mu.Unlock()
+					return
+				}
+				err := app.changeSpeed(step.Speed)
+				if err != nil {
+					slog.Error("changeSpeed", "err", err)
+				}
+				app.mu.Unlock()
+
+				select {
+				case <-ctx.Done():
+					slog.Info("program cancelled", "name", program.Name)
+					return
+				case <-time.After(step.Duration):
+				}
+			}
+		}
+
+		slog.Info("program finished", "name", program.Name)
+		app.mu.Lock()
+		defer app.mu.Unlock()
+		if ctx.Err() == nil && app.state.conn.Is(connectionStateReady) && app.state.started {
+			err := app.pauseBelt()
+			if err != nil {
+				slog.Error("pauseBelt", "err", err)
+			}
+			app.updateUI()
+		}
+	}()
+}
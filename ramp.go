@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+const (
+	rampSpeedStep     = 0.5
+	startRampInterval = 2 * time.Second
+	stopRampStepDelay = 1 * time.Second
+
+	// the cooldown has to be long enough to spread the steps across it, and not so long that it overflows
+	minCooldown = 1 * time.Second
+	maxCooldown = 1 * time.Hour
+)
+
+// cancelRamp stops any running speed ramp or program, e.g. because the user changed the speed manually.
+func (app *App) cancelRamp() {
+	if app.rampCancel != nil {
+		app.rampCancel()
+		app.rampCancel = nil
+	}
+}
+
+// cooldownDuration converts the cooldown in minutes, as given by the user, and checks that it is within its limits.
+func cooldownDuration(minutes float64) (time.Duration, error) {
+	// written as a negation, so that NaN is rejected as well
+	if !(minutes >= minCooldown.Minutes() && minutes <= maxCooldown.Minutes()) {
+		return 0, fmt.Errorf("invalid cooldown: %v, must be within [%v, %v] minutes", minutes, minCooldown.Minutes(),
+			maxCooldown.Minutes())
+	}
+	return time.Duration(minutes * float64(time.Minute)), nil
+}
+
+// cooldownAndStop gradually lowers the speed over the given duration and stops the belt afterward. The speed is
+// decreased in steps of rampSpeedStep, which are spread evenly across the duration. Like startRamp, it must be called
+// with mu held, which the background goroutine takes for every step.
+func (app *App) cooldownAndStop(duration time.Duration) {
+	if !app.state.conn.Is(connectionStateReady) || !app.state.started {
+		return
+	}
+
+	duration = min(max(duration, minCooldown), maxCooldown)
+	app.cancelRamp()
+	ctx, cancel := context.WithCancel(context.Background())
+	app.rampCancel = cancel
+
+	var speeds []float64
+	for speed := app.state.status.Speed - rampSpeedStep; speed >= rampSpeedStep; speed -= rampSpeedStep {
+		speeds = append(speeds, speed)
+	}
+	interval := duration / time.Duration(len(speeds)+1)
+
+	slog.Info("start cooldown", "duration", duration, "steps", len(speeds))
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for i := 0; i <= len(speeds); i++ {
+			select {
+			case <-ctx.Done():
+				slog.Info("cooldown cancelled")
+				return
+			case <-ticker.C:
+			}
+
+			if !app.cooldownStep(ctx, speeds, i) {
+				return
+			}
+		}
+	}()
+}
+
+// cooldownStep lowers the speed to the i-th speed of the cooldown, or stops the belt after the last one. It returns
+// false if the cooldown is over.
+func (app *App) cooldownStep(ctx context.Context, speeds []float64, i int) bool {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+
+	// the cooldown might have been cancelled while waiting for the lock
+	if ctx.Err() != nil || !app.state.conn.Is(connectionStateReady) || !app.state.started {
+		return false
+	}
+	if i < len(speeds) {
+		err := app.changeSpeed(speeds[i])
+		if err != nil {
+			slog.Error("changeSpeed", "err", err)
+		}
+		return true
+	}
+
+	err := app.pauseBelt()
+	if err != nil {
+		slog.Error("pauseBelt", "err", err)
+	}
+	app.updateUI()
+	return false
+}
+
+// startRamp raises the speed of the just started belt by rampSpeedStep every startRampInterval until it reaches the
+// target speed. Like any ramp, it is cancelled by a manual speed change, pause, or stop. It must be called with mu
+// held, which the background goroutine takes for every step.
+func (app *App) startRamp(target float64) {
+	app.cancelRamp()
+	ctx, cancel := context.WithCancel(context.Background())
+	app.rampCancel = cancel
+
+	slog.Info("start ramp", "target", target)
+
+	go func() {
+		ticker := time.NewTicker(startRampInterval)
+		defer ticker.Stop()
+
+		for speed := 2 * rampSpeedStep; ; speed += rampSpeedStep {
+			select {
+			case <-ctx.Done():
+				slog.Info("ramp cancelled")
+				return
+			case <-ticker.C:
+			}
+
+			app.mu.Lock()
+			// the ramp might have been cancelled while waiting for the lock
+			if ctx.Err() != nil || !app.state.conn.Is(connectionStateReady) || !app.state.started {
+				app.mu.Unlock()
+				return
+			}
+			err := app.changeSpeed(min(speed, target))
+			if err != nil {
+				slog.Error("changeSpeed", "err", err)
+			}
+			app.mu.Unlock()
+			if speed >= target {
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestCooldownDuration(t *testing.T) {
+	tests := []struct {
+		minutes float64
+		want    time.Duration
+		wantErr bool
+	}{
+		{minutes: 1, want: time.Minute},
+		{minutes: 0.5, want: 30 * time.Second},
+		{minutes: 60, want: time.Hour},
+		{minutes: 1e-10, wantErr: true},
+		{minutes: 0, wantErr: true},
+		{minutes: -1, wantErr: true},
+		{minutes: 61, wantErr: true},
+		{minutes: math.Inf(1), wantErr: true},
+		{minutes: math.NaN(), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := cooldownDuration(tt.minutes)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("cooldownDuration(%v): expected error: %v, got %v", tt.minutes, tt.wantErr, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("cooldownDuration(%v) = %v, want %v", tt.minutes, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket that allows bursts of up to burst requests and refills at rate requests per second.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	return &rateLimiter{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+func (l *rateLimiter) Allow() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
+
+// rateLimited rejects requests with 429 Too Many Requests once the limiter is exhausted.
+func rateLimited(limiter *rateLimiter, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !limiter.Allow() {
+			writeError(w, http.StatusTooManyRequests, errors.New("rate limit exceeded"))
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// coalescer delays calls to Do and only executes the last one, once no new call arrived for the given delay.
+type coalescer struct {
+	mu    sync.Mutex
+	delay time.Duration
+	timer *time.Timer
+}
+
+func (c *coalescer) Do(fn func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+	c.timer = time.AfterFunc(c.delay, fn)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+)
+
+const (
+	// sessionSaveInterval is how often the running session is saved, so that it can be resumed after a restart.
+	sessionSaveInterval = 5 * time.Second
+	// sessionResumeWindow is how old a saved session may be to be resumed after a restart.
+	sessionResumeWindow = 3 * time.Minute
+)
+
+// savedSession is the in-progress session, which is persisted while the belt is running.
+type savedSession struct {
+	SavedAt         time.Time     `json:"saved_at"`
+	StartAt         time.Time     `json:"start_ts"`
+	TimeAccum       time.Duration `json:"time_accum"`
+	TimeAccumTotal  time.Duration `json:"time_accum_total"`
+	Steps           int           `json:"steps"`
+	StepsTotal      int           `json:"steps_total"`
+	DistanceKm      float64       `json:"distance_km"`
+	DistanceKmTotal float64       `json:"distance_km_total"`
+}
+
+func savedSessionPath() (string, error) {
+	return configSiblingPath("_session.json")
+}
+
+// loadSavedSession reads the session saved by a previous run. It returns nil if there is none.
+func loadSavedSession() (*savedSession, error) {
+	sessionPath, err := savedSessionPath()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(sessionPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read session file: %w", err)
+	}
+
+	var session savedSession
+	err = json.Unmarshal(data, &session)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode session file: %w", err)
+	}
+	return &session, nil
+}
+
+// saveSession persists the running session, at most every sessionSaveInterval.
+func (app *App) saveSession() {
+	if time.Since(app.state.sessionSavedAt) < sessionSaveInterval {
+		return
+	}
+	app.state.sessionSavedAt = time.Now()
+
+	sessionPath, err := savedSessionPath()
+	if err != nil {
+		slog.Error("savedSessionPath", "err", err)
+		return
+	}
+
+	data, err := json.Marshal(savedSession{
+		SavedAt:         app.state.sessionSavedAt,
+		StartAt:         app.state.startedAt,
+		TimeAccum:       app.state.timeAccum,
+		TimeAccumTotal:  app.state.timeAccumTotal,
+		Steps:           app.state.stepsAccum,
+		StepsTotal:      app.state.stepsAccumTotal,
+		DistanceKm:      app.state.kmAccum,
+		DistanceKmTotal: app.state.kmAccumTotal,
+	})
+	if err != nil {
+		slog.Error("failed to marshal session", "err", err)
+		return
+	}
+
+	err = os.WriteFile(sessionPath, data, 0644)
+	if err != nil {
+		slog.Error("failed to write session file", "err", err)
+	}
+}
+
+// removeSavedSession deletes the saved session once it was completed or discarded.
+func (app *App) removeSavedSession() {
+	sessionPath, err := savedSessionPath()
+	if err != nil {
+		slog.Error("savedSessionPath", "err", err)
+		return
+	}
+	err = os.Remove(sessionPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		slog.Error("failed to remove session file", "err", err)
+	}
+}
+
+// resumeSavedSession continues the session saved by a previous run, if it was saved recently. It is only called while
+// the belt is running, i.e. if the app was restarted during a walk. It returns false if there was nothing to resume.
+func (app *App) resumeSavedSession() bool {
+	session := app.state.resumable
+	app.state.resumable = nil
+	if session == nil || time.Since(session.SavedAt) > sessionResumeWindow {
+		return false
+	}
+
+	slog.Info("resume session after restart", "started_at", session.StartAt, "saved_at", session.SavedAt)
+	app.state.started = true
+	app.state.startedAt = session.StartAt
+	app.state.sessionStartedAt = session.StartAt
+	app.state.totalStartedAt = session.StartAt
+	app.state.timeAccum = session.TimeAccum
+	app.state.timeAccumTotal = session.TimeAccumTotal
+	app.state.stepsAccum = session.Steps
+	app.state.stepsAccumTotal = session.StepsTotal
+	app.state.kmAccum = session.DistanceKm
+	app.state.kmAccumTotal = session.DistanceKmTotal
+	return true
+}
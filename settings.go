@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/getlantern/systray"
+)
+
+var webhookThresholdChoices = []time.Duration{1 * time.Minute, 5 * time.Minute, 10 * time.Minute, 15 * time.Minute, 30 * time.Minute}
+
+type settingsState struct {
+	// defaultSpeed is the target speed the app starts with
+	defaultSpeed float64
+
+	mDefaultSpeedItems     []speedItem
+	mWebhookThresholdItems []thresholdItem
+	mUnitsItems            []unitsItem
+}
+
+type thresholdItem struct {
+	threshold time.Duration
+	item      *systray.MenuItem
+}
+
+type unitsItem struct {
+	units Units
+	item  *systray.MenuItem
+}
+
+// setupSettingsUI adds the settings menu. Changed settings take effect immediately and are saved to the config file.
+func (app *App) setupSettingsUI(speeds []float64) {
+	app.settings.defaultSpeed = app.TargetSpeed
+
+	mSettings := systray.AddMenuItem("Settings", "")
+
+	mDefaultSpeed := mSettings.AddSubMenuItem("Default speed", "")
+	app.settings.mDefaultSpeedItems = app.addSpeedItems(mDefaultSpeed, speeds, true)
+	for _, si := range app.settings.mDefaultSpeedItems {
+		go func() {
+			for {
+				<-si.item.ClickedCh
+				app.mu.Lock()
+				app.setDefaultSpeed(si.speed)
+				app.mu.Unlock()
+			}
+		}()
+	}
+
+	mThreshold := mSettings.AddSubMenuItem("Webhook threshold", "")
+	for _, threshold := range webhookThresholdChoices {
+		item := mThreshold.AddSubMenuItemCheckbox(fmt.Sprintf("%.0f min", threshold.Minutes()), "", false)
+		item.ClickedCh = make(chan struct{})
+		app.settings.mWebhookThresholdItems = append(app.settings.mWebhookThresholdItems, thresholdItem{threshold: threshold, item: item})
+		go func() {
+			for {
+				<-item.ClickedCh
+				app.mu.Lock()
+				app.setWebhookThreshold(threshold)
+				app.mu.Unlock()
+			}
+		}()
+	}
+
+	mUnits := mSettings.AddSubMenuItem("Units", "")
+	for _, u := range []struct {
+		units Units
+		label string
+	}{
+		{UnitsMetric, "Metric"},
+		{UnitsImperial, "Imperial"},
+	} {
+		units := u.units
+		item := mUnits.AddSubMenuItemCheckbox(u.label, "", false)
+		item.ClickedCh = make(chan struct{})
+		app.settings.mUnitsItems = append(app.settings.mUnitsItems, unitsItem{units: units, item: item})
+		go func() {
+			for {
+				<-item.ClickedCh
+				app.mu.Lock()
+				app.setUnits(units)
+				app.mu.Unlock()
+			}
+		}()
+	}
+}
+
+func (app *App) setDefaultSpeed(speed float64) {
+	err := updateConfig(map[string]any{"targetSpeed": speed})
+	if err != nil {
+		slog.Error("failed to save default speed", "err", err)
+		return
+	}
+	app.settings.defaultSpeed = speed
+	app.updateSettingsUI()
+}
+
+func (app *App) setWebhookThreshold(threshold time.Duration) {
+	err := updateConfig(map[string]any{"webhookThresholdMin": threshold.Minutes()})
+	if err != nil {
+		slog.Error("failed to save webhook threshold", "err", err)
+		return
+	}
+	app.WebhookThreshold = threshold
+	app.updateSettingsUI()
+}
+
+func (app *App) setUnits(units Units) {
+	err := updateConfig(map[string]any{"units": units})
+	if err != nil {
+		slog.Error("failed to save units", "err", err)
+		return
+	}
+	app.Units = units
+
+	// speed labels are only set once, so they have to be updated to the new unit system
+	for _, si := range app.mSpeedItems {
+		si.item.SetTitle(app.speedLabel(si.speed))
+	}
+	for _, si := range app.settings.mDefaultSpeedItems {
+		si.item.SetTitle(app.speedLabel(si.speed))
+	}
+	for _, sg := range app.mSpeedGroups {
+		sg.item.SetTitle(app.speedGroupLabel(sg.first, sg.last))
+	}
+	app.updateUI()
+}
+
+func (app *App) speedLabel(speed float64) string {
+	return fmt.Sprintf("%.1f %s", app.Units.Speed(speed), app.Units.SpeedUnit())
+}
+
+func (app *App) speedGroupLabel(first, last float64) string {
+	return fmt.Sprintf("%.1f - %s", app.Units.Speed(first), app.speedLabel(last))
+}
+
+func (app *App) updateSettingsUI() {
+	for _, si := range app.settings.mDefaultSpeedItems {
+		if sameSpeed(si.speed, app.settings.defaultSpeed) {
+			si.item.Check()
+			continue
+		}
+		si.item.Uncheck()
+	}
+	for _, ti := range app.settings.mWebhookThresholdItems {
+		if ti.threshold == app.WebhookThreshold {
+			ti.item.Check()
+			continue
+		}
+		ti.item.Uncheck()
+	}
+	for _, ui := range app.settings.mUnitsItems {
+		// an unset unit system defaults to metric
+		if ui.units == app.Units || (app.Units == "" && ui.units == UnitsMetric) {
+			ui.item.Check()
+			continue
+		}
+		ui.item.Uncheck()
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+)
+
+type connectionState byte
+
+const (
+	connectionStateDisconnected connectionState = iota
+	connectionStateScanning
+	connectionStateConnecting
+	connectionStateConnected
+	connectionStateReady
+)
+
+func (s connectionState) String() string {
+	switch s {
+	case connectionStateDisconnected:
+		return "disconnected"
+	case connectionStateScanning:
+		return "scanning"
+	case connectionStateConnecting:
+		return "connecting"
+	case connectionStateConnected:
+		return "connected"
+	case connectionStateReady:
+		return "ready"
+	default:
+		return fmt.Sprintf("unknown(%d)", byte(s))
+	}
+}
+
+// validTransitions lists all states that can be reached from a given state. Every state can always fall back to
+// disconnected, so that a failed scan or connect never leaves the app stuck in an intermediate state. A known pad is
+// connected to without scanning.
+var validTransitions = map[connectionState][]connectionState{
+	connectionStateDisconnected: {connectionStateScanning, connectionStateConnecting},
+	connectionStateScanning:     {connectionStateDisconnected, connectionStateConnecting},
+	connectionStateConnecting:   {connectionStateDisconnected, connectionStateConnected},
+	connectionStateConnected:    {connectionStateDisconnected, connectionStateReady},
+	connectionStateReady:        {connectionStateDisconnected},
+}
+
+// stateMachine tracks the connection state of the app and guards against invalid transitions.
+type stateMachine struct {
+	current connectionState
+}
+
+func (sm *stateMachine) Current() connectionState {
+	return sm.current
+}
+
+func (sm *stateMachine) Is(state connectionState) bool {
+	return sm.current == state
+}
+
+// Transition moves the state machine into the given state. If the transition is not allowed, the state is left
+// unchanged and an error is returned. Transitioning into the current state is a no-op.
+func (sm *stateMachine) Transition(to connectionState) error {
+	if sm.current == to {
+		return nil
+	}
+	for _, state := range validTransitions[sm.current] {
+		if state == to {
+			sm.current = to
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid state transition: %s -> %s", sm.current, to)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"testing"
+)
+
+var connectionStates = []connectionState{
+	connectionStateDisconnected,
+	connectionStateScanning,
+	connectionStateConnecting,
+	connectionStateConnected,
+	connectionStateReady,
+}
+
+func TestStateMachineTransition(t *testing.T) {
+	// the expected transitions are listed explicitly instead of being read from validTransitions, so that a change of
+	// the table is caught by this test
+	valid := map[[2]connectionState]bool{
+		{connectionStateDisconnected, connectionStateScanning}:   true,
+		{connectionStateDisconnected, connectionStateConnecting}: true,
+		{connectionStateScanning, connectionStateDisconnected}:   true,
+		{connectionStateScanning, connectionStateConnecting}:     true,
+		{connectionStateConnecting, connectionStateDisconnected}: true,
+		{connectionStateConnecting, connectionStateConnected}:    true,
+		{connectionStateConnected, connectionStateDisconnected}:  true,
+		{connectionStateConnected, connectionStateReady}:         true,
+		{connectionStateReady, connectionStateDisconnected}:      true,
+	}
+
+	for _, from := range connectionStates {
+		for _, to := range connectionStates {
+			t.Run(from.String()+"->"+to.String(), func(t *testing.T) {
+				sm := stateMachine{current: from}
+				err := sm.Transition(to)
+
+				// transitioning into the current state is always allowed
+				wantValid := from == to || valid[[2]connectionState{from, to}]
+				if wantValid && err != nil {
+					t.Fatalf("expected transition to be allowed, got: %v", err)
+				}
+				if !wantValid && err == nil {
+					t.Fatal("expected transition to be rejected")
+				}
+
+				want := from
+				if wantValid {
+					want = to
+				}
+				if sm.Current() != want {
+					t.Errorf("expected state %s, got %s", want, sm.Current())
+				}
+			})
+		}
+	}
+}
+
+func TestStateMachineSequences(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps []connectionState
+	}{
+		{
+			name:  "connect after scan",
+			steps: []connectionState{connectionStateScanning, connectionStateConnecting, connectionStateConnected, connectionStateReady},
+		},
+		{
+			name:  "connect directly",
+			steps: []connectionState{connectionStateConnecting, connectionStateConnected, connectionStateReady},
+		},
+		{
+			name:  "scan after failed direct connect",
+			steps: []connectionState{connectionStateConnecting, connectionStateDisconnected, connectionStateScanning},
+		},
+		{
+			name:  "no pad found",
+			steps: []connectionState{connectionStateScanning, connectionStateDisconnected},
+		},
+		{
+			name:  "connection lost",
+			steps: []connectionState{connectionStateScanning, connectionStateConnecting, connectionStateConnected, connectionStateReady, connectionStateDisconnected, connectionStateScanning},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sm stateMachine
+			for _, step := range tt.steps {
+				err := sm.Transition(step)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestStateMachineDisconnectFromAnyState(t *testing.T) {
+	for _, from := range connectionStates {
+		t.Run(from.String(), func(t *testing.T) {
+			sm := stateMachine{current: from}
+			err := sm.Transition(connectionStateDisconnected)
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// See: https://developers.strava.com/docs/reference/#api-Activities-createActivity
+const stravaActivitiesURL = "https://www.strava.com/api/v3/activities"
+
+var errStravaUnauthorized = errors.New("strava access token is expired or invalid")
+
+// uploadStrava creates a manual walk activity for the session on Strava.
+func (app *App) uploadStrava(session webhookPayload) error {
+	form := url.Values{
+		"name":             {"Walking Pad"},
+		"sport_type":       {"Walk"},
+		"start_date_local": {session.StartAt.Format("2006-01-02T15:04:05")},
+		"elapsed_time":     {strconv.Itoa(int(session.Duration.Seconds()))},
+		"distance":         {strconv.FormatFloat(session.DistanceKm*1000, 'f', 0, 64)},
+		"description":      {fmt.Sprintf("%d steps", session.Steps)},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, stravaActivitiesURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "Bearer "+*app.StravaAccessToken)
+
+	slog.Info("upload strava activity", "start_ts", session.StartAt)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("send request: %w", err)
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusCreated, http.StatusOK:
+		return nil
+	case http.StatusUnauthorized:
+		return errStravaUnauthorized
+	default:
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The status stream is served via a minimal WebSocket implementation, which only sends text frames to the client and
+// answers its close frames.
+// See: https://datatracker.ietf.org/doc/html/rfc6455
+
+const (
+	maxStreamSubscribers = 10
+	streamBufferSize     = 16
+	streamWriteTimeout   = 5 * time.Second
+
+	websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+	websocketOpText  = 0x1
+	websocketOpClose = 0x8
+	websocketOpPing  = 0x9
+	websocketOpPong  = 0xA
+)
+
+var errTooManySubscribers = errors.New("too many stream subscribers")
+
+// streamHub fans out status updates to all stream subscribers. Updates are dropped for subscribers that do not keep
+// up, so a slow client never blocks the app.
+type streamHub struct {
+	mu          sync.Mutex
+	subscribers map[chan []byte]struct{}
+}
+
+func newStreamHub() *streamHub {
+	return &streamHub{subscribers: make(map[chan []byte]struct{})}
+}
+
+func (h *streamHub) subscribe() (chan []byte, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.subscribers) >= maxStreamSubscribers {
+		return nil, errTooManySubscribers
+	}
+	ch := make(chan []byte, streamBufferSize)
+	h.subscribers[ch] = struct{}{}
+	return ch, nil
+}
+
+func (h *streamHub) unsubscribe(ch chan []byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.subscribers, ch)
+}
+
+func (h *streamHub) publish(msg []byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for ch := range h.subscribers {
+		select {
+		case ch <- msg:
+		default:
+		}
+	}
+}
+
+// publishStream sends the current status to all stream subscribers.
+func (app *App) publishStream() {
+	if app.stream == nil {
+		return
+	}
+	msg, err := json.Marshal(app.statusResponse())
+	if err != nil {
+		slog.Error("marshal stream status", "err", err)
+		return
+	}
+	app.stream.publish(msg)
+}
+
+// handleStream upgrades the connection to a WebSocket and pushes the status on every stats update until the client
+// disconnects.
+func (app *App) handleStream(w http.ResponseWriter, r *http.Request) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") || key == "" ||
+		r.Header.Get("Sec-WebSocket-Version") != "13" {
+		writeError(w, http.StatusBadRequest, errors.New("expected websocket upgrade"))
+		return
+	}
+
+	app.mu.Lock()
+	stream := app.stream
+	app.mu.Unlock()
+
+	ch, err := stream.subscribe()
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	defer stream.unsubscribe(ch)
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errors.New("connection cannot be upgraded"))
+		return
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		slog.Error("hijack stream connection", "err", err)
+		return
+	}
+	defer func() { _ = conn.Close() }()
+
+	accept := sha1.Sum([]byte(key + websocketGUID))
+	_, err = fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\n"+
+		"Upgrade: websocket\r\n"+
+		"Connection: Upgrade\r\n"+
+		"Sec-WebSocket-Accept: %s\r\n\r\n", base64.StdEncoding.EncodeToString(accept[:]))
+	if err == nil {
+		err = rw.Flush()
+	}
+	if err != nil {
+		slog.Error("upgrade stream connection", "err", err)
+		return
+	}
+
+	// writes are serialized, as control frames are answered by the reader
+	var writeMu sync.Mutex
+	write := func(opcode byte, payload []byte) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		_ = conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+		return writeWebsocketFrame(conn, opcode, payload)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		readWebsocket(rw.Reader, write)
+	}()
+
+	// send the current status right away, so that the client does not have to wait for the next update
+	app.mu.Lock()
+	app.publishStream()
+	app.mu.Unlock()
+
+	for {
+		select {
+		case msg := <-ch:
+			err := write(websocketOpText, msg)
+			if err != nil {
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// readWebsocket reads frames from the client until it closes the connection. Data frames are ignored.
+func readWebsocket(r *bufio.Reader, write func(opcode byte, payload []byte) error) {
+	for {
+		opcode, payload, err := readWebsocketFrame(r)
+		if err != nil {
+			return
+		}
+		switch opcode {
+		case websocketOpClose:
+			_ = write(websocketOpClose, payload)
+			return
+		case websocketOpPing:
+			_ = write(websocketOpPong, payload)
+		}
+	}
+}
+
+func readWebsocketFrame(r io.Reader) (opcode byte, payload []byte, err error) {
+	header := make([]byte, 2)
+	_, err = io.ReadFull(r, header)
+	if err != nil {
+		return 0, nil, err
+	}
+	opcode = header[0] & 0x0F
+	masked := header[1]&0x80 != 0
+
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		ext := make([]byte, 2)
+		_, err = io.ReadFull(r, ext)
+		length = uint64(binary.BigEndian.Uint16(ext))
+	case 127:
+		ext := make([]byte, 8)
+		_, err = io.ReadFull(r, ext)
+		length = binary.BigEndian.Uint64(ext)
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	// clients only send small control frames to the stream
+	if length > 1<<16 {
+		return 0, nil, fmt.Errorf("frame too large: %d", length)
+	}
+
+	var mask [4]byte
+	if masked {
+		_, err = io.ReadFull(r, mask[:])
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+
+	payload = make([]byte, length)
+	_, err = io.ReadFull(r, payload)
+	if err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return opcode, payload, nil
+}
+
+// writeWebsocketFrame writes a single unmasked frame, as servers must not mask their frames.
+func writeWebsocketFrame(conn net.Conn, opcode byte, payload []byte) error {
+	frame := []byte{0x80 | opcode} // final fragment
+	switch {
+	case len(payload) < 126:
+		frame = append(frame, byte(len(payload)))
+	case len(payload) <= 0xFFFF:
+		frame = append(frame, 126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+	default:
+		frame = append(frame, 127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(len(payload)))
+	}
+	frame = append(frame, payload...)
+
+	_, err := conn.Write(frame)
+	return err
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// lifetimeTotals are the stats accumulated across all sessions since the app was first started.
+type lifetimeTotals struct {
+	DurationMin float64 `json:"duration_min"`
+	Steps       int     `json:"steps"`
+	DistanceKm  float64 `json:"distance_km"`
+}
+
+func lifetimeTotalsPath() (string, error) {
+	return configSiblingPath("_totals.json")
+}
+
+// loadLifetimeTotals reads the persisted totals. If none were persisted yet, zero totals are returned.
+func loadLifetimeTotals() (lifetimeTotals, error) {
+	totalsPath, err := lifetimeTotalsPath()
+	if err != nil {
+		return lifetimeTotals{}, err
+	}
+
+	totalsFile, err := os.Open(totalsPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return lifetimeTotals{}, nil
+	}
+	if err != nil {
+		return lifetimeTotals{}, fmt.Errorf("failed to open totals file: %w", err)
+	}
+	defer func() { _ = totalsFile.Close() }()
+
+	var totals lifetimeTotals
+	err = json.NewDecoder(totalsFile).Decode(&totals)
+	if err != nil {
+		return lifetimeTotals{}, fmt.Errorf("failed to decode totals file: %w", err)
+	}
+
+	return totals, nil
+}
+
+func saveLifetimeTotals(totals lifetimeTotals) error {
+	totalsPath, err := lifetimeTotalsPath()
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(totals)
+	if err != nil {
+		return fmt.Errorf("failed to marshal totals: %w", err)
+	}
+
+	err = os.WriteFile(totalsPath, data, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write totals file: %w", err)
+	}
+
+	return nil
+}
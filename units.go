@@ -0,0 +1,45 @@
+package main
+
+// Units defines the unit system used to present distances and speeds. The pad itself always reports metric values.
+type Units string
+
+const (
+	UnitsMetric   Units = "metric"
+	UnitsImperial Units = "imperial"
+)
+
+const kmPerMile = 1.609344
+
+func kmToMiles(km float64) float64 {
+	return km / kmPerMile
+}
+
+// Distance converts the distance from km into the unit system.
+func (u Units) Distance(km float64) float64 {
+	if u == UnitsImperial {
+		return kmToMiles(km)
+	}
+	return km
+}
+
+// Speed converts the speed from km/h into the unit system.
+func (u Units) Speed(kmh float64) float64 {
+	if u == UnitsImperial {
+		return kmToMiles(kmh)
+	}
+	return kmh
+}
+
+func (u Units) DistanceUnit() string {
+	if u == UnitsImperial {
+		return "mi"
+	}
+	return "km"
+}
+
+func (u Units) SpeedUnit() string {
+	if u == UnitsImperial {
+		return "mph"
+	}
+	return "km/h"
+}
@@ -1,9 +1,14 @@
 package main
 
 import (
+	"cmp"
 	"context"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"log/slog"
+	"math"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -42,176 +47,458 @@ func mustUUID(uuid string) bluetooth.UUID {
 
 type WalkingPadCandidate struct {
 	Device bluetooth.ScanResult
+
+	// MatchedService is the advertised service UUID that identified the device as a walking pad.
+	MatchedService bluetooth.UUID
 }
 
-func FindWalkingPadCandidates(adapter *bluetooth.Adapter, timeout time.Duration, targetAddr *string) ([]WalkingPadCandidate, error) {
-	go func() {
-		<-time.After(timeout)
-		_ = adapter.StopScan()
-	}()
+// FindWalkingPadCandidates scans for walking pads until the timeout passes or the preferred device was found. The
+// candidates are ordered from the best to the worst match.
+func FindWalkingPadCandidates(adapter Adapter, timeout time.Duration, preferred *string) ([]WalkingPadCandidate, error) {
+	// the timeout is cancelled if the scan stops early, so that it cannot stop a later scan
+	timer := time.AfterFunc(timeout, func() { _ = adapter.StopScan() })
+	defer timer.Stop()
 
 	var (
-		set     = make(map[string]struct{})
-		devices []WalkingPadCandidate
+		seen           = make(map[string]int) // address -> index in devices
+		devices        []WalkingPadCandidate
+		foundPreferred bool
 	)
-	err := adapter.Scan(func(adapter *bluetooth.Adapter, device bluetooth.ScanResult) {
-		for _, uuid := range walkingPadUUIDs {
-			if device.HasServiceUUID(uuid) {
-				if _, ok := set[device.Address.String()]; ok {
-					return
-				}
-				set[device.Address.String()] = struct{}{}
+	err := adapter.Scan(func(device bluetooth.ScanResult) {
+		// a device is only marked as seen once it matched, so that a non-matching advertisement does not hide it
+		uuid, ok := matchWalkingPad(device)
+		if !ok {
+			return
+		}
 
-				devices = append(devices, WalkingPadCandidate{Device: device})
+		// devices advertise repeatedly, and the name might only be part of a later scan response, so repeated
+		// advertisements update the existing candidate instead of adding another one
+		i, ok := seen[device.Address.String()]
+		if !ok {
+			i = len(devices)
+			seen[device.Address.String()] = i
+			devices = append(devices, WalkingPadCandidate{Device: device, MatchedService: uuid})
+		} else if devices[i].Device.LocalName() == "" && device.LocalName() != "" {
+			devices[i].Device = device
+		} else {
+			devices[i].Device.RSSI = device.RSSI
+		}
 
-				if targetAddr != nil && device.Address.String() == *targetAddr {
-					_ = adapter.StopScan()
-					return
-				}
-			}
+		if !foundPreferred && preferred != nil && isPreferredDevice(devices[i].Device, *preferred) {
+			foundPreferred = true
+			_ = adapter.StopScan()
 		}
 	})
 	if err != nil {
 		return nil, err
 	}
 
+	// the preferred device comes first, followed by the others from the strongest to the weakest signal
+	slices.SortStableFunc(devices, func(a, b WalkingPadCandidate) int {
+		aPreferred := preferred != nil && isPreferredDevice(a.Device, *preferred)
+		bPreferred := preferred != nil && isPreferredDevice(b.Device, *preferred)
+		if aPreferred != bPreferred {
+			if aPreferred {
+				return -1
+			}
+			return 1
+		}
+		return cmp.Compare(b.Device.RSSI, a.Device.RSSI)
+	})
+
 	return devices, nil
 }
 
-func (candidate WalkingPadCandidate) Connect(adapter *bluetooth.Adapter, params bluetooth.ConnectionParams) (*WalkingPad, error) {
-	device, err := adapter.Connect(candidate.Device.Address, params)
-	if err != nil {
-		return nil, fmt.Errorf("connect: %w", err)
+// parseAddress parses the address of a device in the format of the platform, i.e. a MAC address or, on macOS, a UUID.
+// It returns false for anything else, e.g. a name prefix.
+func parseAddress(value string) (bluetooth.Address, bool) {
+	var address bluetooth.Address
+	address.Set(value)
+	// Set ignores invalid values, so the address only counts if it was taken over
+	return address, strings.EqualFold(address.String(), value)
+}
+
+// matchWalkingPad tries all known walking pad services on the device and returns the first one it advertises.
+func matchWalkingPad(device bluetooth.ScanResult) (bluetooth.UUID, bool) {
+	for _, uuid := range walkingPadUUIDs {
+		if device.HasServiceUUID(uuid) {
+			return uuid, true
+		}
+	}
+	return bluetooth.UUID{}, false
+}
+
+// isPreferredDevice reports whether the device matches the preferred device, which is either an address or, if
+// prefixed with "name:", a prefix of the advertised local name.
+func isPreferredDevice(device bluetooth.ScanResult, preferred string) bool {
+	if prefix, ok := strings.CutPrefix(preferred, "name:"); ok {
+		return strings.HasPrefix(device.LocalName(), prefix)
 	}
+	return device.Address.String() == preferred
+}
+
+const (
+	defaultStatsInterval = 3 * time.Second
+	minStatsInterval     = 1 * time.Second
+	defaultCommandDelay  = 700 * time.Millisecond
+	minCommandDelay      = 300 * time.Millisecond
+)
 
-	services, err := device.DiscoverServices(walkingPadUUIDs)
+// WalkingPadOptions configure how often the pad is talked to. Zero values use the defaults, and values below the
+// minimums are raised to them, so that the pad is not overwhelmed.
+type WalkingPadOptions struct {
+	// StatsInterval is the interval in which the stats are requested.
+	StatsInterval time.Duration
+	// CommandDelay is the delay after every written command, except for read-only ones like AskStats.
+	CommandDelay time.Duration
+	// LogRawFrames logs every received and written buffer as hex, e.g. to reverse-engineer unknown frames.
+	LogRawFrames bool
+}
+
+func (opts WalkingPadOptions) withDefaults() WalkingPadOptions {
+	if opts.StatsInterval == 0 {
+		opts.StatsInterval = defaultStatsInterval
+	}
+	if opts.CommandDelay == 0 {
+		opts.CommandDelay = defaultCommandDelay
+	}
+	opts.StatsInterval = max(opts.StatsInterval, minStatsInterval)
+	opts.CommandDelay = max(opts.CommandDelay, minCommandDelay)
+	return opts
+}
+
+func (candidate WalkingPadCandidate) Connect(adapter Adapter, opts WalkingPadOptions) (*WalkingPad, error) {
+	conn, err := adapter.Connect(candidate.Device.Address)
 	if err != nil {
-		return nil, fmt.Errorf("discover services: %w", err)
+		return nil, err
 	}
 
-	var (
-		rxFound, txFound bool
-		rx               bluetooth.DeviceCharacteristic
-		tx               bluetooth.DeviceCharacteristic
-	)
-	for _, service := range services {
-		characteristics, err := service.DiscoverCharacteristics(nil)
+	address := candidate.Device.Address.String()
+	model, ok := deviceModels.get(address)
+	if !ok {
+		model, err = conn.ReadModel()
 		if err != nil {
-			return nil, fmt.Errorf("discover characteristics: %w", err)
-		}
-
-		for _, ch := range characteristics {
-			if strings.HasPrefix(ch.UUID().String(), "0000fe01") {
-				rx = ch
-				rxFound = true
-			}
-			if strings.HasPrefix(ch.UUID().String(), "0000fe02") {
-				tx = ch
-				txFound = true
-			}
+			slog.Error("failed to read walking pad model", "device", address, "err", err)
+		} else if model != "" {
+			deviceModels.set(address, model)
 		}
 	}
 
-	if !rxFound || !txFound {
-		return nil, fmt.Errorf("missing characteristics")
+	pad := newWalkingPad(candidate.Device.Address, conn)
+	pad.opts = opts.withDefaults()
+	pad.Model = model
+	pad.MatchedService = candidate.MatchedService
+	// candidates that are connected directly were never scanned, so the service is only known after discovering it
+	if pad.MatchedService == (bluetooth.UUID{}) {
+		pad.MatchedService = conn.Service()
 	}
+	pad.profile = defaultWalkingPadProfile
+	slog.Info("read walking pad model", "device", address, "model", model)
 
-	pad := newWalkingPad(device, rx, tx)
-	_ = pad.rx.EnableNotifications(pad.onBufferReceive)
+	_ = conn.Notify(pad.onBufferReceive)
 
-	var ctx context.Context
-	ctx, pad.cancel = context.WithCancel(context.Background())
+	pad.ctx, pad.cancel = context.WithCancel(context.Background())
 
 	pad.wg.Add(2)
-	go pad.writeLoop(ctx)
-	go pad.askStatsLoop(ctx)
+	go pad.writeLoop(pad.ctx)
+	go pad.askStatsLoop(pad.ctx)
 
 	return pad, nil
 }
 
-type WalkingPad struct {
-	device bluetooth.Device
-	rx     bluetooth.DeviceCharacteristic
-	tx     bluetooth.DeviceCharacteristic
+// modelCache caches the model string per device address, so that it is only read on the first connect. Pads are
+// connected from different goroutines, e.g. by the main loop and the CLI.
+type modelCache struct {
+	mu     sync.Mutex
+	models map[string]string
+}
+
+func (c *modelCache) get(address string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	model, ok := c.models[address]
+	return model, ok
+}
+
+func (c *modelCache) set(address, model string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.models[address] = model
+}
+
+var deviceModels = &modelCache{models: make(map[string]string)}
+
+// maxEncodableSpeed is the highest speed the protocol can represent, as speeds are sent as speed*10 in a single byte.
+const maxEncodableSpeed = 25.5
 
-	wg      sync.WaitGroup
-	cancel  context.CancelFunc
-	stopped bool
+// walkingPadProfile describes model specific protocol behaviour.
+type walkingPadProfile struct {
+	Name     string
+	MaxSpeed float64
+
+	// ReadStatus decodes a status frame without its header bytes, for models whose frame layout differs.
+	ReadStatus func(buf []byte) (WalkingPadStatus, bool)
+}
+
+// defaultWalkingPadProfile is used for all models, as no model is known yet whose protocol differs. The model is read
+// nevertheless, as it is reported in the status.
+var defaultWalkingPadProfile = walkingPadProfile{
+	Name:       "default",
+	MaxSpeed:   6,
+	ReadStatus: readStatusBuffer,
+}
+
+type WalkingPad struct {
+	address bluetooth.Address
+	conn    PadConnection
+	profile walkingPadProfile
+	opts    WalkingPadOptions
+
+	wg     sync.WaitGroup
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// stop is closed on disconnect, so that commands are rejected, including those waiting for room in the queue. The
+	// queue itself is never closed, so that a command cannot be sent on a closed queue.
+	stop     chan struct{}
+	stopOnce sync.Once
+	// mu serializes dropping queued commands, so that the kept commands are queued again in their original order
+	mu sync.Mutex
 
 	queue chan walkingPadCommand
 
-	LastStatus     WalkingPadStatus
-	LastStatusTime time.Time
+	// Model is the model string reported by the device, if available.
+	Model string
+	// MatchedService is the service UUID that identified the device as a walking pad during discovery.
+	MatchedService bluetooth.UUID
+
+	// statusMu guards the last status, which is written by the bluetooth adapter and read by the app and the CLI
+	statusMu       sync.Mutex
+	lastStatus     WalkingPadStatus
+	lastStatusTime time.Time
+}
+
+// LastStatus returns the last status reported by the pad and when it was received. The time is zero if the pad did not
+// report a status yet.
+func (pad *WalkingPad) LastStatus() (WalkingPadStatus, time.Time) {
+	pad.statusMu.Lock()
+	defer pad.statusMu.Unlock()
+	return pad.lastStatus, pad.lastStatusTime
 }
 
 type walkingPadCommand struct {
 	timeout time.Duration
 	buffer  []byte
+	done    chan struct{}
+	// unpaced skips the command delay after the buffer was written. It is only used for read-only commands, which do
+	// not change the state of the device.
+	unpaced bool
 }
 
-func newWalkingPad(device bluetooth.Device, rx, tx bluetooth.DeviceCharacteristic) *WalkingPad {
+func newWalkingPad(address bluetooth.Address, conn PadConnection) *WalkingPad {
 	return &WalkingPad{
-		device: device,
-		rx:     rx,
-		tx:     tx,
-		queue:  make(chan walkingPadCommand, 50),
+		address: address,
+		conn:    conn,
+		stop:    make(chan struct{}),
+		queue:   make(chan walkingPadCommand, 50),
 	}
 }
 
 func (pad *WalkingPad) Disconnect() {
-	if pad.stopped {
+	stopped := false
+	pad.stopOnce.Do(func() {
+		close(pad.stop)
+		stopped = true
+	})
+	if !stopped {
 		return
 	}
-	pad.stopped = true
 
-	close(pad.queue)
 	pad.cancel()
 	pad.wg.Wait()
-	_ = pad.device.Disconnect()
+	_ = pad.conn.Disconnect()
 }
 
-func (pad *WalkingPad) pushCmd(cmd []byte, timeout time.Duration) {
+var errPadDisconnected = errors.New("walking pad is disconnected")
+
+// enqueue adds the command to the queue. If the queue is full, it waits for room. It fails if the pad was disconnected,
+// also while waiting.
+func (pad *WalkingPad) enqueue(cmd walkingPadCommand) error {
+	select {
+	case <-pad.stop:
+		return errPadDisconnected
+	default:
+	}
+
+	select {
+	case pad.queue <- cmd:
+		return nil
+	case <-pad.stop:
+		return errPadDisconnected
+	}
+}
+
+func (pad *WalkingPad) pushCmd(cmd []byte, timeout time.Duration) error {
 	fixCrc(cmd)
-	pad.queue <- walkingPadCommand{timeout: timeout, buffer: cmd}
+	return pad.enqueue(walkingPadCommand{timeout: timeout, buffer: cmd})
+}
+
+func (pad *WalkingPad) ChangeMode(mode WalkingPadMode) error {
+	return pad.pushCmd([]byte{247, 162, 2, byte(mode), 0xFF, 253}, 0)
+}
+
+func (pad *WalkingPad) StartBelt() error {
+	return pad.pushCmd([]byte{247, 162, 4, 1, 0xFF, 253}, 0)
+}
+
+// StopBelt stops the belt. Start and speed commands that are still queued are dropped, so that the belt cannot start
+// moving again after the stop.
+func (pad *WalkingPad) StopBelt() error {
+	pad.dropPendingMovement()
+	return pad.ChangeSpeed(0.0)
+}
+
+// StopBeltGradually lowers the speed in steps of the given size, waiting stepDelay after each step, before stopping
+// the belt. Like StopBelt, it drops queued start and speed commands first. A later StopBelt drops the remaining steps.
+func (pad *WalkingPad) StopBeltGradually(from, step float64, stepDelay time.Duration) error {
+	pad.dropPendingMovement()
+
+	for i := int(math.Ceil(from/step)) - 1; i > 0; i-- {
+		err := pad.ChangeSpeed(float64(i) * step)
+		if err != nil {
+			return err
+		}
+		err = pad.WaitCmd(stepDelay)
+		if err != nil {
+			return err
+		}
+	}
+	return pad.ChangeSpeed(0.0)
 }
 
-func (pad *WalkingPad) ChangeMode(mode WalkingPadMode) {
-	pad.pushCmd([]byte{247, 162, 2, byte(mode), 0xFF, 253}, 0)
+// dropPendingMovement removes all queued commands that would start or speed up the belt, including the waits between
+// them. Other commands are queued again in their original order.
+func (pad *WalkingPad) dropPendingMovement() {
+	pad.mu.Lock()
+	defer pad.mu.Unlock()
+
+	var keep []walkingPadCommand
+	for {
+		select {
+		case cmd := <-pad.queue:
+			if cmd.done == nil && (cmd.buffer == nil || isMovementCmd(cmd.buffer)) {
+				slog.Debug("drop queued command on stop", "buf", cmd.buffer)
+				continue
+			}
+			keep = append(keep, cmd)
+			continue
+		default:
+		}
+		break
+	}
+	for _, cmd := range keep {
+		// commands queued meanwhile might have taken the room, in which case this waits like enqueue does
+		if pad.enqueue(cmd) != nil {
+			return
+		}
+	}
 }
 
-func (pad *WalkingPad) StartBelt() {
-	pad.pushCmd([]byte{247, 162, 4, 1, 0xFF, 253}, 0)
+// isMovementCmd reports whether the command starts the belt or changes its speed.
+func isMovementCmd(cmd []byte) bool {
+	return len(cmd) > 2 && cmd[0] == 247 && cmd[1] == 162 && (cmd[2] == 1 || cmd[2] == 4)
 }
 
-func (pad *WalkingPad) StopBelt() {
-	pad.ChangeSpeed(0.0)
+// EmergencyStop writes a stop command directly to the device, bypassing the command queue. It is a last resort when
+// the connection is about to be lost and queued commands would never be sent.
+func (pad *WalkingPad) EmergencyStop() error {
+	cmd := []byte{247, 162, 1, 0, 0xFF, 253}
+	fixCrc(cmd)
+	return pad.conn.Write(cmd)
 }
 
-func (pad *WalkingPad) ChangeSpeed(speed float64) {
-	if speed < 0 || speed > 6 {
-		panic("invalid speed")
+func (pad *WalkingPad) ChangeSpeed(speed float64) error {
+	if speed < 0 || speed > pad.profile.MaxSpeed || speed > maxEncodableSpeed {
+		return fmt.Errorf("invalid speed %.1f: must be within [0, %.1f]", speed, min(pad.profile.MaxSpeed, maxEncodableSpeed))
 	}
 	cnv := byte(speed * 10.0)
-	pad.pushCmd([]byte{247, 162, 1, cnv, 0xFF, 253}, 0)
+	return pad.pushCmd([]byte{247, 162, 1, cnv, 0xFF, 253}, 0)
+}
+
+// SetMaxSpeed configures the maximum speed of the belt on the device. Speeds outside the range supported by the device
+// are rejected.
+func (pad *WalkingPad) SetMaxSpeed(speed float64) error {
+	if speed <= 0 || speed > pad.profile.MaxSpeed {
+		return fmt.Errorf("invalid max speed %.1f: must be within (0, %.1f]", speed, pad.profile.MaxSpeed)
+	}
+	return pad.setPref(walkingPadPrefMaxSpeed, int(speed*10.0))
 }
 
-func (pad *WalkingPad) AskStats() {
-	pad.pushCmd([]byte{247, 162, 0, 0, 162, 253}, 0)
+// SetLock enables or disables the child lock, which prevents the belt from being controlled via the device buttons.
+func (pad *WalkingPad) SetLock(locked bool) error {
+	value := 0
+	if locked {
+		value = 1
+	}
+	return pad.setPref(walkingPadPrefChildLock, value)
 }
 
-func (pad *WalkingPad) WaitCmd(timeout time.Duration) {
-	pad.pushCmd(nil, timeout)
+func (pad *WalkingPad) setPref(key walkingPadPref, value int) error {
+	return pad.pushCmd([]byte{247, 166, 0, byte(key), byte(value >> 16), byte(value >> 8), byte(value), 0xFF, 253}, 0)
+}
+
+// AskStats requests the current status. It only reads from the device, so the next command is not delayed by it.
+func (pad *WalkingPad) AskStats() error {
+	cmd := []byte{247, 162, 0, 0, 0xFF, 253}
+	fixCrc(cmd)
+	return pad.enqueue(walkingPadCommand{buffer: cmd, unpaced: true})
+}
+
+func (pad *WalkingPad) WaitCmd(timeout time.Duration) error {
+	return pad.pushCmd(nil, timeout)
+}
+
+// Flush blocks until all previously queued commands were written to the device, the pad was disconnected, or the
+// timeout passed. It returns false if not all commands were written.
+func (pad *WalkingPad) Flush(timeout time.Duration) bool {
+	done := make(chan struct{})
+	if pad.enqueue(walkingPadCommand{done: done}) != nil {
+		return false
+	}
+	select {
+	case <-done:
+		return true
+	case <-pad.ctx.Done():
+		return false
+	case <-time.After(timeout):
+		return false
+	}
 }
 
 func (pad *WalkingPad) onBufferReceive(buf []byte) {
+	if pad.opts.LogRawFrames {
+		slog.Info("receive frame", "device", pad.address.String(), "hex", hex.EncodeToString(buf))
+	}
+
 	if len(buf) < 2 {
 		return
 	}
 
+	if !verifyCrc(buf) {
+		slog.Debug("drop walking pad frame with invalid crc", "buf", buf)
+		return
+	}
+
 	if buf[0] == 248 && buf[1] == 162 {
-		status := readStatusBuffer(buf[2:])
-		pad.LastStatus = status
-		pad.LastStatusTime = time.Now()
+		status, ok := pad.profile.ReadStatus(buf[2:])
+		if !ok {
+			slog.Debug("drop truncated walking pad status frame", "buf", buf)
+			return
+		}
+		pad.statusMu.Lock()
+		pad.lastStatus = status
+		pad.lastStatusTime = time.Now()
+		pad.statusMu.Unlock()
 		return
 	}
 }
@@ -228,12 +515,20 @@ func (pad *WalkingPad) writeLoop(ctx context.Context) {
 				time.Sleep(cmd.timeout)
 			}
 			if cmd.buffer != nil {
-				_, err := pad.tx.WriteWithoutResponse(cmd.buffer)
+				if pad.opts.LogRawFrames {
+					slog.Info("write frame", "device", pad.address.String(), "hex", hex.EncodeToString(cmd.buffer))
+				}
+				err := pad.conn.Write(cmd.buffer)
 				if err != nil {
 					slog.Error("error writing to bluetooth device", "err", err)
 				}
 
-				time.Sleep(700 * time.Millisecond)
+				if !cmd.unpaced {
+					time.Sleep(pad.opts.CommandDelay)
+				}
+			}
+			if cmd.done != nil {
+				close(cmd.done)
 			}
 		}
 	}
@@ -242,21 +537,24 @@ func (pad *WalkingPad) writeLoop(ctx context.Context) {
 func (pad *WalkingPad) askStatsLoop(ctx context.Context) {
 	defer pad.wg.Done()
 
-	ticket := time.NewTicker(3 * time.Second)
+	ticket := time.NewTicker(pad.opts.StatsInterval)
 	defer ticket.Stop()
 
-	pad.AskStats()
+	_ = pad.AskStats()
 
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case <-ticket.C:
-			pad.AskStats()
+			_ = pad.AskStats()
 		}
 	}
 }
 
+// fixCrc sets the checksum of the frame, which is its second to last byte. The checksum is the sum of all bytes between
+// the start byte and the checksum, i.e. bytes 1 to len-3, while the start and the end byte are not covered. For
+// [247, 162, 1, 25, crc, 253], this is 162+1+25.
 func fixCrc(cmd []byte) {
 	if len(cmd) < 2 {
 		return
@@ -268,6 +566,18 @@ func fixCrc(cmd []byte) {
 	cmd[len(cmd)-2] = sum
 }
 
+// verifyCrc checks the checksum of a received frame, which is computed the same way as for sent commands.
+func verifyCrc(buf []byte) bool {
+	if len(buf) < 3 {
+		return false
+	}
+	var sum byte
+	for i := 1; i < len(buf)-2; i++ {
+		sum += buf[i] // overflow intended
+	}
+	return buf[len(buf)-2] == sum
+}
+
 type WalkingPadMode byte
 
 const (
@@ -276,22 +586,95 @@ const (
 	WalkingPadModeAuto    WalkingPadMode = 0
 )
 
+func (m WalkingPadMode) String() string {
+	switch m {
+	case WalkingPadModeStandby:
+		return "standby"
+	case WalkingPadModeManual:
+		return "manual"
+	case WalkingPadModeAuto:
+		return "auto"
+	default:
+		return fmt.Sprintf("unknown (%d)", byte(m))
+	}
+}
+
+// MarshalText encodes the mode by its name, so that consumers do not depend on the protocol values.
+func (m WalkingPadMode) MarshalText() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+type walkingPadPref byte
+
+const (
+	walkingPadPrefMaxSpeed  walkingPadPref = 3
+	walkingPadPrefChildLock walkingPadPref = 8
+)
+
+// WalkingPadBeltState is the state of the belt as reported by the device.
+type WalkingPadBeltState byte
+
+const (
+	WalkingPadBeltStateIdle     WalkingPadBeltState = 0
+	WalkingPadBeltStateRunning  WalkingPadBeltState = 1
+	WalkingPadBeltStateStandby  WalkingPadBeltState = 5
+	WalkingPadBeltStateStarting WalkingPadBeltState = 9
+)
+
+// Known reports whether the state is a regular operating state. Other states are reported by the device if the belt
+// cannot be operated, e.g. because it needs to be calibrated.
+func (s WalkingPadBeltState) Known() bool {
+	switch s {
+	case WalkingPadBeltStateIdle, WalkingPadBeltStateRunning, WalkingPadBeltStateStandby, WalkingPadBeltStateStarting:
+		return true
+	default:
+		return false
+	}
+}
+
+// Moving reports whether the belt is running or about to run.
+func (s WalkingPadBeltState) Moving() bool {
+	return s == WalkingPadBeltStateRunning || s == WalkingPadBeltStateStarting
+}
+
 type WalkingPadStatus struct {
-	Speed    float64
-	Mode     WalkingPadMode
-	Time     time.Duration
-	WalkedKM float64
-	Steps    int
+	BeltState WalkingPadBeltState
+	Speed     float64
+	Mode      WalkingPadMode
+	Time      time.Duration
+	WalkedKM  float64
+	Steps     int
+	// ControlCode is the last event reported by the controller of the pad, e.g. a button press or an error. The values
+	// are not documented and differ between models, so they are only passed through.
+	ControlCode byte
 }
 
-func readStatusBuffer(buf []byte) WalkingPadStatus {
+const (
+	// statusBufferMinLen is the minimum length of a status frame after its two header bytes.
+	statusBufferMinLen = 12
+	// statusBufferControlIndex is the index of the control code, which is only sent by some models. It is followed by
+	// the crc and the end byte of the frame.
+	statusBufferControlIndex = 14
+)
+
+// readStatusBuffer decodes a status frame without its header bytes. It returns false if the frame is too short.
+func readStatusBuffer(buf []byte) (WalkingPadStatus, bool) {
+	if len(buf) < statusBufferMinLen {
+		return WalkingPadStatus{}, false
+	}
+
 	timeS := int(buf[3])<<16 | int(buf[4])<<8 | int(buf[5])
 	dist := int(buf[6])<<16 | int(buf[7])<<8 | int(buf[8])
-	return WalkingPadStatus{
-		Speed:    float64(buf[1]) / 10.0,
-		Mode:     WalkingPadMode(buf[2]),
-		Time:     time.Duration(timeS) * time.Second,
-		WalkedKM: float64(dist) / 100.0,
-		Steps:    int(buf[9])<<16 | int(buf[10])<<8 | int(buf[11]),
+	status := WalkingPadStatus{
+		BeltState: WalkingPadBeltState(buf[0]),
+		Speed:     float64(buf[1]) / 10.0,
+		Mode:      WalkingPadMode(buf[2]),
+		Time:      time.Duration(timeS) * time.Second,
+		WalkedKM:  float64(dist) / 100.0,
+		Steps:     int(buf[9])<<16 | int(buf[10])<<8 | int(buf[11]),
+	}
+	if len(buf) > statusBufferControlIndex+2 {
+		status.ControlCode = buf[statusBufferControlIndex]
 	}
+	return status, true
 }
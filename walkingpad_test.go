@@ -0,0 +1,582 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"tinygo.org/x/bluetooth"
+)
+
+// newTestPad returns a pad whose queue is not processed, so that the queued commands can be inspected.
+func newTestPad() *WalkingPad {
+	pad := newWalkingPad(bluetooth.Address{}, nil)
+	pad.profile = defaultWalkingPadProfile
+	return pad
+}
+
+// queuedCommands removes all commands from the queue of the pad and returns them in order.
+func queuedCommands(pad *WalkingPad) []walkingPadCommand {
+	var cmds []walkingPadCommand
+	for {
+		select {
+		case cmd := <-pad.queue:
+			cmds = append(cmds, cmd)
+		default:
+			return cmds
+		}
+	}
+}
+
+func TestWalkingPadCommands(t *testing.T) {
+	tests := []struct {
+		name    string
+		send    func(pad *WalkingPad) error
+		want    [][]byte
+		wantErr bool
+	}{
+		{
+			name: "change speed",
+			send: func(pad *WalkingPad) error { return pad.ChangeSpeed(2.5) },
+			want: [][]byte{{247, 162, 1, 25, 188, 253}},
+		},
+		{
+			name: "change speed to max speed",
+			send: func(pad *WalkingPad) error { return pad.ChangeSpeed(6) },
+			want: [][]byte{{247, 162, 1, 60, 223, 253}},
+		},
+		{
+			name:    "change speed above max speed",
+			send:    func(pad *WalkingPad) error { return pad.ChangeSpeed(6.5) },
+			wantErr: true,
+		},
+		{
+			name:    "change speed to negative speed",
+			send:    func(pad *WalkingPad) error { return pad.ChangeSpeed(-1) },
+			wantErr: true,
+		},
+		{
+			name: "change mode to manual",
+			send: func(pad *WalkingPad) error { return pad.ChangeMode(WalkingPadModeManual) },
+			want: [][]byte{{247, 162, 2, 1, 165, 253}},
+		},
+		{
+			name: "change mode to standby",
+			send: func(pad *WalkingPad) error { return pad.ChangeMode(WalkingPadModeStandby) },
+			want: [][]byte{{247, 162, 2, 2, 166, 253}},
+		},
+		{
+			name: "change mode to auto",
+			send: func(pad *WalkingPad) error { return pad.ChangeMode(WalkingPadModeAuto) },
+			want: [][]byte{{247, 162, 2, 0, 164, 253}},
+		},
+		{
+			name: "start belt",
+			send: func(pad *WalkingPad) error { return pad.StartBelt() },
+			want: [][]byte{{247, 162, 4, 1, 167, 253}},
+		},
+		{
+			name: "stop belt",
+			send: func(pad *WalkingPad) error { return pad.StopBelt() },
+			want: [][]byte{{247, 162, 1, 0, 163, 253}},
+		},
+		{
+			name: "stop belt drops pending movement",
+			send: func(pad *WalkingPad) error {
+				_ = pad.StartBelt()
+				_ = pad.WaitCmd(time.Second)
+				_ = pad.ChangeSpeed(2.5)
+				_ = pad.ChangeMode(WalkingPadModeManual)
+				return pad.StopBelt()
+			},
+			want: [][]byte{{247, 162, 2, 1, 165, 253}, {247, 162, 1, 0, 163, 253}},
+		},
+		{
+			name: "set max speed",
+			send: func(pad *WalkingPad) error { return pad.SetMaxSpeed(6) },
+			want: [][]byte{{247, 166, 0, 3, 0, 0, 60, 229, 253}},
+		},
+		{
+			name:    "set max speed above max speed",
+			send:    func(pad *WalkingPad) error { return pad.SetMaxSpeed(6.5) },
+			wantErr: true,
+		},
+		{
+			name: "lock",
+			send: func(pad *WalkingPad) error { return pad.SetLock(true) },
+			want: [][]byte{{247, 166, 0, 8, 0, 0, 1, 175, 253}},
+		},
+		{
+			name: "unlock",
+			send: func(pad *WalkingPad) error { return pad.SetLock(false) },
+			want: [][]byte{{247, 166, 0, 8, 0, 0, 0, 174, 253}},
+		},
+		{
+			name: "set pref with large value",
+			send: func(pad *WalkingPad) error { return pad.setPref(walkingPadPrefMaxSpeed, 0x010203) },
+			want: [][]byte{{247, 166, 0, 3, 1, 2, 3, 175, 253}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pad := newTestPad()
+			err := tt.send(pad)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error: %v, got %v", tt.wantErr, err)
+			}
+
+			var got [][]byte
+			for _, cmd := range queuedCommands(pad) {
+				got = append(got, cmd.buffer)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("expected frames %v, got %v", tt.want, got)
+			}
+			for i := range got {
+				if !bytes.Equal(got[i], tt.want[i]) {
+					t.Errorf("frame %d: expected %v, got %v", i, tt.want[i], got[i])
+				}
+			}
+		})
+	}
+}
+
+func TestFixCrc(t *testing.T) {
+	tests := []struct {
+		name string
+		cmd  []byte
+		want []byte
+	}{
+		{name: "command", cmd: []byte{247, 162, 1, 25, 0xFF, 253}, want: []byte{247, 162, 1, 25, 188, 253}},
+		{name: "overflow", cmd: []byte{247, 200, 100, 0xFF, 253}, want: []byte{247, 200, 100, 44, 253}},
+		{name: "no payload", cmd: []byte{247, 0xFF, 253}, want: []byte{247, 0, 253}},
+		{name: "too short", cmd: []byte{247}, want: []byte{247}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fixCrc(tt.cmd)
+			if !bytes.Equal(tt.cmd, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, tt.cmd)
+			}
+		})
+	}
+}
+
+func TestVerifyCrc(t *testing.T) {
+	tests := []struct {
+		name string
+		buf  []byte
+		want bool
+	}{
+		{name: "valid", buf: []byte{247, 162, 1, 25, 188, 253}, want: true},
+		{name: "valid with overflow", buf: []byte{247, 200, 100, 44, 253}, want: true},
+		{name: "invalid crc", buf: []byte{247, 162, 1, 25, 189, 253}, want: false},
+		{name: "corrupted payload", buf: []byte{247, 162, 1, 26, 188, 253}, want: false},
+		{name: "too short", buf: []byte{247, 253}, want: false},
+		{name: "empty", buf: nil, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := verifyCrc(tt.buf)
+			if got != tt.want {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestAskStats(t *testing.T) {
+	pad := newTestPad()
+	err := pad.AskStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmds := queuedCommands(pad)
+	if len(cmds) != 1 {
+		t.Fatalf("expected 1 command, got %d", len(cmds))
+	}
+	want := []byte{247, 162, 0, 0, 162, 253}
+	if !bytes.Equal(cmds[0].buffer, want) {
+		t.Errorf("expected %v, got %v", want, cmds[0].buffer)
+	}
+	if !verifyCrc(cmds[0].buffer) {
+		t.Error("expected a valid crc")
+	}
+	// requesting the stats does not change the state of the pad, so the next command is not delayed
+	if !cmds[0].unpaced {
+		t.Error("expected the stats request to be unpaced")
+	}
+}
+
+func TestReadStatusBuffer(t *testing.T) {
+	status := WalkingPadStatus{
+		BeltState:   WalkingPadBeltStateRunning,
+		Speed:       2.5,
+		Mode:        WalkingPadModeManual,
+		Time:        70000 * time.Second,
+		WalkedKM:    123.45,
+		Steps:       70001,
+		ControlCode: 7,
+	}
+	frame := encodeStatusFrame(status)
+
+	withoutControl := status
+	withoutControl.ControlCode = 0
+
+	tests := []struct {
+		name   string
+		buf    []byte
+		want   WalkingPadStatus
+		wantOk bool
+	}{
+		{name: "full frame", buf: frame[2:], want: status, wantOk: true},
+		{name: "without control code", buf: frame[2:14], want: withoutControl, wantOk: true},
+		{name: "control code without crc", buf: frame[2:17], want: withoutControl, wantOk: true},
+		{name: "one byte short", buf: frame[2:13], wantOk: false},
+		{name: "header only", buf: frame[2:3], wantOk: false},
+		{name: "empty", buf: nil, wantOk: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := readStatusBuffer(tt.buf)
+			if ok != tt.wantOk {
+				t.Fatalf("expected ok: %v, got %v", tt.wantOk, ok)
+			}
+			if got != tt.want {
+				t.Errorf("expected %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestOnBufferReceiveDropsMalformedFrames(t *testing.T) {
+	frame := encodeStatusFrame(WalkingPadStatus{BeltState: WalkingPadBeltStateRunning, Speed: 2.5, Steps: 100})
+
+	truncated := append([]byte{}, frame[:10]...)
+	truncated = append(truncated, 0xFF, 253)
+	fixCrc(truncated)
+
+	corrupted := append([]byte{}, frame...)
+	corrupted[3]++
+
+	tests := []struct {
+		name string
+		buf  []byte
+	}{
+		{name: "empty", buf: nil},
+		{name: "header only", buf: frame[:2]},
+		{name: "truncated", buf: truncated},
+		{name: "invalid crc", buf: corrupted},
+		{name: "unknown frame", buf: []byte{248, 167, 1, 168, 253}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pad := newTestPad()
+			pad.onBufferReceive(tt.buf)
+			if status, at := pad.LastStatus(); !at.IsZero() || status != (WalkingPadStatus{}) {
+				t.Errorf("expected no status, got %+v", status)
+			}
+		})
+	}
+
+	pad := newTestPad()
+	pad.onBufferReceive(frame)
+	if status, at := pad.LastStatus(); status.Steps != 100 || at.IsZero() {
+		t.Errorf("expected the valid frame to be decoded, got %+v", status)
+	}
+}
+
+// TestLastStatusWhileReceiving reads the last status while the bluetooth adapter delivers frames concurrently. It is
+// meant to be run with -race.
+func TestLastStatusWhileReceiving(t *testing.T) {
+	pad := newTestPad()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			pad.onBufferReceive(encodeStatusFrame(WalkingPadStatus{Steps: i}))
+		}
+	}()
+
+	for i := 0; i < 100; i++ {
+		_, _ = pad.LastStatus()
+	}
+	wg.Wait()
+
+	if status, _ := pad.LastStatus(); status.Steps != 99 {
+		t.Errorf("steps = %d, want 99", status.Steps)
+	}
+}
+
+// testAddress returns a distinct address for n in the format of the platform, i.e. a MAC address or, on macOS, a UUID.
+func testAddress(t *testing.T, n byte) bluetooth.Address {
+	t.Helper()
+	for _, value := range []string{
+		fmt.Sprintf("AA:BB:CC:DD:EE:%02X", n),
+		fmt.Sprintf("00000000-0000-0000-0000-0000000000%02x", n),
+	} {
+		if address, ok := parseAddress(value); ok {
+			return address
+		}
+	}
+	t.Fatal("no address format is supported by the platform")
+	return bluetooth.Address{}
+}
+
+// testAdvertisement advertises the given name and, optionally, the walking pad service.
+type testAdvertisement struct {
+	name       string
+	walkingPad bool
+}
+
+func (a testAdvertisement) LocalName() string { return a.name }
+
+func (a testAdvertisement) HasServiceUUID(uuid bluetooth.UUID) bool {
+	return a.walkingPad && uuid == walkingPadUUIDs[0]
+}
+
+func (testAdvertisement) Bytes() []byte { return nil }
+
+func (testAdvertisement) ManufacturerData() []bluetooth.ManufacturerDataElement { return nil }
+
+func (testAdvertisement) ServiceData() []bluetooth.ServiceDataElement { return nil }
+
+// scriptedAdapter reports the given advertisements in order when scanning, until the scan is stopped.
+type scriptedAdapter struct {
+	fakeAdapter
+	advertisements []bluetooth.ScanResult
+	stopped        bool
+}
+
+func (a *scriptedAdapter) Scan(callback func(device bluetooth.ScanResult)) error {
+	for _, advertisement := range a.advertisements {
+		if a.stopped {
+			break
+		}
+		callback(advertisement)
+	}
+	return nil
+}
+
+func (a *scriptedAdapter) StopScan() error {
+	a.stopped = true
+	return nil
+}
+
+func TestFindWalkingPadCandidates(t *testing.T) {
+	type advertisement struct {
+		address    byte
+		name       string
+		rssi       int16
+		walkingPad bool
+	}
+	type candidate struct {
+		address byte
+		name    string
+		rssi    int16
+	}
+
+	tests := []struct {
+		name           string
+		preferred      string
+		advertisements []advertisement
+		want           []candidate
+	}{
+		{
+			name: "name from a later scan response",
+			advertisements: []advertisement{
+				{address: 1, rssi: -70, walkingPad: true},
+				{address: 1, name: "WalkingPad", rssi: -65, walkingPad: true},
+			},
+			want: []candidate{{address: 1, name: "WalkingPad", rssi: -65}},
+		},
+		{
+			name: "name is kept if a later advertisement has none",
+			advertisements: []advertisement{
+				{address: 1, name: "WalkingPad", rssi: -70, walkingPad: true},
+				{address: 1, rssi: -60, walkingPad: true},
+			},
+			want: []candidate{{address: 1, name: "WalkingPad", rssi: -60}},
+		},
+		{
+			name: "non-matching advertisement does not hide the device",
+			advertisements: []advertisement{
+				{address: 1, name: "WalkingPad", rssi: -70},
+				{address: 1, name: "WalkingPad", rssi: -60, walkingPad: true},
+			},
+			want: []candidate{{address: 1, name: "WalkingPad", rssi: -60}},
+		},
+		{
+			name: "ordered by the latest signal strength",
+			advertisements: []advertisement{
+				{address: 1, name: "A", rssi: -80, walkingPad: true},
+				{address: 2, name: "B", rssi: -60, walkingPad: true},
+				{address: 1, name: "A", rssi: -50, walkingPad: true},
+			},
+			want: []candidate{{address: 1, name: "A", rssi: -50}, {address: 2, name: "B", rssi: -60}},
+		},
+		{
+			name:      "preferred name from a later scan response",
+			preferred: "name:Pad",
+			advertisements: []advertisement{
+				{address: 1, rssi: -80, walkingPad: true},
+				{address: 2, name: "Other", rssi: -40, walkingPad: true},
+				{address: 1, name: "Pad", rssi: -80, walkingPad: true},
+				{address: 3, name: "Late", rssi: -30, walkingPad: true},
+			},
+			want: []candidate{{address: 1, name: "Pad", rssi: -80}, {address: 2, name: "Other", rssi: -40}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			adapter := &scriptedAdapter{}
+			for _, a := range tt.advertisements {
+				adapter.advertisements = append(adapter.advertisements, bluetooth.ScanResult{
+					Address:              testAddress(t, a.address),
+					RSSI:                 a.rssi,
+					AdvertisementPayload: testAdvertisement{name: a.name, walkingPad: a.walkingPad},
+				})
+			}
+			var preferred *string
+			if tt.preferred != "" {
+				preferred = &tt.preferred
+			}
+
+			devices, err := FindWalkingPadCandidates(adapter, time.Minute, preferred)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []candidate
+			for _, device := range devices {
+				var address byte
+				for n := byte(0); n < 10; n++ {
+					if device.Device.Address == testAddress(t, n) {
+						address = n
+					}
+				}
+				got = append(got, candidate{address: address, name: device.Device.LocalName(), rssi: device.Device.RSSI})
+				if device.MatchedService != walkingPadUUIDs[0] {
+					t.Errorf("expected matched service %s, got %s", walkingPadUUIDs[0], device.MatchedService)
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("expected %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestDisconnectWithFullQueue(t *testing.T) {
+	pad := newTestPad()
+	pad.conn = &fakeConnection{adapter: &fakeAdapter{}}
+	pad.ctx, pad.cancel = context.WithCancel(context.Background())
+
+	// nothing processes the queue, so it fills up and the remaining commands wait for room
+	const senders = 2 * 50
+	errs := make(chan error, senders)
+	for i := 0; i < senders; i++ {
+		go func() { errs <- pad.ChangeSpeed(2.5) }()
+	}
+	for len(pad.queue) < cap(pad.queue) {
+		time.Sleep(time.Millisecond)
+	}
+
+	disconnected := make(chan struct{})
+	go func() {
+		pad.Disconnect()
+		close(disconnected)
+	}()
+	select {
+	case <-disconnected:
+	case <-time.After(time.Second):
+		t.Fatal("disconnect is blocked by the commands waiting for room in the queue")
+	}
+
+	var queued, rejected int
+	for i := 0; i < senders; i++ {
+		select {
+		case err := <-errs:
+			if err == nil {
+				queued++
+			} else if errors.Is(err, errPadDisconnected) {
+				rejected++
+			} else {
+				t.Errorf("unexpected error: %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("command is still waiting after disconnect")
+		}
+	}
+	if queued != cap(pad.queue) || rejected != senders-cap(pad.queue) {
+		t.Errorf("expected %d queued and %d rejected commands, got %d and %d",
+			cap(pad.queue), senders-cap(pad.queue), queued, rejected)
+	}
+
+	err := pad.ChangeSpeed(2.5)
+	if !errors.Is(err, errPadDisconnected) {
+		t.Errorf("expected commands after disconnect to be rejected, got %v", err)
+	}
+}
+
+// modelConnection counts how often the model is read.
+type modelConnection struct {
+	*recordingConnection
+	reads atomic.Int32
+}
+
+func (conn *modelConnection) ReadModel() (string, error) {
+	conn.reads.Add(1)
+	return "P1", nil
+}
+
+func TestConnectCachesModel(t *testing.T) {
+	conn := &modelConnection{recordingConnection: &recordingConnection{}}
+	adapter := &connectionAdapter{conn: conn}
+	conn.adapter = &adapter.fakeAdapter
+	candidate := WalkingPadCandidate{Device: bluetooth.ScanResult{Address: testAddress(t, 42)}}
+
+	connect := func() {
+		pad, err := candidate.Connect(adapter, WalkingPadOptions{})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		pad.Disconnect()
+		if pad.Model != "P1" {
+			t.Errorf("model = %q, want %q", pad.Model, "P1")
+		}
+	}
+
+	// pads are connected concurrently by the app and the CLI, which must not race on the cache
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			connect()
+		}()
+	}
+	wg.Wait()
+
+	reads := conn.reads.Load()
+	connect()
+	if got := conn.reads.Load(); got != reads {
+		t.Errorf("model was read again although it is cached: %d reads, want %d", got, reads)
+	}
+}
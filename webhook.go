@@ -0,0 +1,434 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	webhookRetryBaseDelay = 10 * time.Second
+	webhookRetryMaxDelay  = 10 * time.Minute
+
+	// deliveryQueueSize is the number of sessions that can wait for deliverSessions, which might be busy with a slow
+	// endpoint.
+	deliveryQueueSize = 16
+	// maxPendingDeliveries limits the failed deliveries that are kept for a retry. The oldest ones are dropped first.
+	maxPendingDeliveries = 20
+	// maxDeliveryAge is how long failed deliveries are retried, as a webhook that keeps failing for longer is unlikely
+	// to recover, and the session would be outdated anyway.
+	maxDeliveryAge = 24 * time.Hour
+)
+
+// webhookTarget describes where and how a webhook is delivered.
+type webhookTarget struct {
+	URL     string
+	Method  string
+	Headers map[string]string
+}
+
+// webhookPayload is a snapshot of the session stats, so that it can be delivered after the session was reset.
+type webhookPayload struct {
+	StartAt     time.Time
+	Duration    time.Duration
+	Elapsed     time.Duration
+	Steps       int
+	DistanceKm  float64
+	Calories    float64
+	TargetSpeed float64
+	Speed       float64
+	AvgHR       float64
+	MaxHR       int
+
+	SpeedBreakdown map[string]float64
+}
+
+func (app *App) webhookPayload() webhookPayload {
+	return webhookPayload{
+		StartAt:     app.state.startedAt,
+		Duration:    app.state.timeAccum,
+		Elapsed:     app.sessionElapsed(),
+		Steps:       app.state.stepsAccum,
+		DistanceKm:  app.state.kmAccum,
+		Calories:    estimateCalories(app.state.kmAccum, app.state.timeAccum, app.bodyWeightKg()),
+		TargetSpeed: app.TargetSpeed,
+		AvgHR:       app.state.heartRate.avg(),
+		MaxHR:       app.state.heartRate.max,
+
+		SpeedBreakdown: app.state.speedBreakdown(),
+	}
+}
+
+// sendSessionStartWebhook notifies all session start webhooks that the belt started. The webhooks are sent in the
+// background and not retried, as a late notification would be outdated anyway.
+func (app *App) sendSessionStartWebhook() {
+	if len(app.SessionStartWebhookURLs) == 0 {
+		return
+	}
+
+	payload := webhookPayload{
+		StartAt:     app.state.startedAt,
+		TargetSpeed: app.TargetSpeed,
+	}
+	for _, webhookURL := range app.SessionStartWebhookURLs {
+		target := webhookTarget{
+			URL:     webhookURL,
+			Method:  app.WebhookMethod,
+			Headers: app.WebhookHeaders,
+		}
+		go func() {
+			err := deliverWebhook(target, payload, 1)
+			if err != nil {
+				slog.Error("deliverWebhook", "err", err)
+			}
+		}()
+	}
+}
+
+// sendHeartbeatWebhook sends the live stats of the running session to all heartbeat webhooks, if HeartbeatInterval
+// passed since the last heartbeat. Like the session start webhook, heartbeats are sent in the background and not
+// retried.
+func (app *App) sendHeartbeatWebhook() {
+	if len(app.HeartbeatWebhookURLs) == 0 || time.Since(app.state.lastHeartbeat) < app.HeartbeatInterval {
+		return
+	}
+	app.state.lastHeartbeat = time.Now()
+
+	payload := webhookPayload{
+		StartAt:     app.state.startedAt,
+		Duration:    app.state.timeAccumTotal,
+		Elapsed:     elapsedSince(app.state.totalStartedAt),
+		Steps:       app.state.stepsAccumTotal,
+		DistanceKm:  app.state.kmAccumTotal,
+		Calories:    estimateCalories(app.state.kmAccumTotal, app.state.timeAccumTotal, app.bodyWeightKg()),
+		TargetSpeed: app.TargetSpeed,
+		Speed:       app.state.status.Speed,
+		AvgHR:       app.state.heartRate.avg(),
+		MaxHR:       app.state.heartRate.max,
+	}
+	for _, webhookURL := range app.HeartbeatWebhookURLs {
+		target := webhookTarget{
+			URL:     webhookURL,
+			Method:  app.WebhookMethod,
+			Headers: app.WebhookHeaders,
+		}
+		go func() {
+			err := deliverWebhook(target, payload, 1)
+			if err != nil {
+				slog.Error("deliverWebhook", "err", err)
+			}
+		}()
+	}
+}
+
+// sessionDelivery is a completed session that still has to be handed off to some of the webhooks or to Strava. It is
+// kept apart from the session history, which already contains the session, so that retrying a failed delivery never
+// records the session again.
+type sessionDelivery struct {
+	payload  webhookPayload
+	webhooks []webhookTarget
+	strava   bool
+	attempt  int
+}
+
+// newSessionDelivery addresses the current session to all webhooks and, if configured, to Strava.
+func (app *App) newSessionDelivery() sessionDelivery {
+	delivery := sessionDelivery{
+		payload: app.webhookPayload(),
+		strava:  app.StravaAccessToken != nil,
+		attempt: 1,
+	}
+	for _, webhookURL := range app.WebhookURLs {
+		delivery.webhooks = append(delivery.webhooks, webhookTarget{
+			URL:     webhookURL,
+			Method:  app.WebhookMethod,
+			Headers: app.WebhookHeaders,
+		})
+	}
+	return delivery
+}
+
+// deliver hands the session to all remaining webhooks and to Strava. A failing webhook does not prevent the others
+// from being sent. If retries are enabled, failed webhooks are retried in the background. Everything else that failed
+// is kept in the delivery, and it returns true once nothing is left.
+func (app *App) deliver(delivery *sessionDelivery) bool {
+	var failed []webhookTarget
+	for _, target := range delivery.webhooks {
+		err := deliverWebhook(target, delivery.payload, delivery.attempt)
+		if err == nil {
+			continue
+		}
+		slog.Error("deliverWebhook", "err", err)
+		if app.WebhookMaxAttempts > 1 {
+			go app.retryWebhook(target, delivery.payload)
+			continue
+		}
+		failed = append(failed, target)
+	}
+	delivery.webhooks = failed
+
+	if delivery.strava {
+		err := app.uploadStrava(delivery.payload)
+		switch {
+		case errors.Is(err, errStravaUnauthorized):
+			// retrying cannot succeed until the token is replaced, which requires a restart anyway
+			slog.Error("uploadStrava, not retried", "err", err)
+			delivery.strava = false
+		case err != nil:
+			slog.Error("uploadStrava", "err", err)
+		default:
+			delivery.strava = false
+		}
+	}
+
+	delivery.attempt++
+	return len(delivery.webhooks) == 0 && !delivery.strava
+}
+
+// queueDelivery hands the delivery to deliverSessions without blocking. A nil delivery only retries the failed ones.
+func (app *App) queueDelivery(delivery *sessionDelivery) {
+	select {
+	case app.deliveries <- delivery:
+	default:
+		if delivery != nil {
+			slog.Error("drop session delivery, too many are queued", "start_ts", delivery.payload.StartAt)
+		}
+	}
+}
+
+// deliverSessions delivers the queued sessions one after another, so that slow endpoints never block the app. The
+// deliveries that failed are retried before every queued one.
+func (app *App) deliverSessions() {
+	var pending []sessionDelivery
+	for delivery := range app.deliveries {
+		pending = app.retryDeliveries(pending)
+		if delivery != nil && !app.deliver(delivery) {
+			pending = append(pending, *delivery)
+		}
+
+		if n := len(pending) - maxPendingDeliveries; n > 0 {
+			for _, dropped := range pending[:n] {
+				slog.Error("drop session delivery, too many failed", "start_ts", dropped.payload.StartAt)
+			}
+			pending = slices.Clone(pending[n:])
+		}
+	}
+}
+
+// retryDeliveries re-attempts the deliveries of earlier sessions that failed, and returns the ones that failed again.
+// Deliveries of sessions older than maxDeliveryAge are dropped instead.
+func (app *App) retryDeliveries(deliveries []sessionDelivery) []sessionDelivery {
+	var pending []sessionDelivery
+	for _, delivery := range deliveries {
+		if time.Since(delivery.payload.StartAt) > maxDeliveryAge {
+			slog.Error("give up session delivery, session is too old", "start_ts", delivery.payload.StartAt,
+				"attempts", delivery.attempt-1, "webhooks", len(delivery.webhooks), "strava", delivery.strava)
+			continue
+		}
+		slog.Info("retry session delivery", "start_ts", delivery.payload.StartAt, "attempt", delivery.attempt)
+		if !app.deliver(&delivery) {
+			pending = append(pending, delivery)
+		}
+	}
+	return pending
+}
+
+// retryWebhook re-attempts the delivery with exponential backoff until it succeeds or WebhookMaxAttempts is reached.
+func (app *App) retryWebhook(target webhookTarget, payload webhookPayload) {
+	delay := webhookRetryBaseDelay
+	for attempt := 2; attempt <= app.WebhookMaxAttempts; attempt++ {
+		slog.Info("retry webhook", "attempt", attempt, "delay", delay)
+		time.Sleep(delay)
+
+		err := deliverWebhook(target, payload, attempt)
+		if err == nil {
+			return
+		}
+		slog.Error("deliverWebhook", "attempt", attempt, "err", err)
+
+		delay = min(2*delay, webhookRetryMaxDelay)
+	}
+	slog.Error("give up webhook delivery", "attempts", app.WebhookMaxAttempts, "start_ts", payload.StartAt)
+}
+
+// webhookBody is sent as JSON for webhooks that use POST.
+type webhookBody struct {
+	StartAt     time.Time `json:"start_ts"`
+	DurationMin float64   `json:"duration_min"`
+	ElapsedMin  float64   `json:"elapsed_min"`
+	Steps       int       `json:"steps"`
+	DistanceKm  float64   `json:"distance_km"`
+	Calories    float64   `json:"calories"`
+	TargetSpeed float64   `json:"target_speed"`
+	Speed       float64   `json:"speed,omitempty"`
+	AvgHR       float64   `json:"avg_hr,omitempty"`
+	MaxHR       int       `json:"max_hr,omitempty"`
+
+	SpeedBreakdown map[string]float64 `json:"speed_breakdown,omitempty"`
+}
+
+// deliverWebhook sends a single request to the webhook and logs the attempt. Placeholders in the URL are replaced for
+// all methods. POST requests additionally carry the session as a JSON body.
+func deliverWebhook(target webhookTarget, payload webhookPayload, attempt int) (err error) {
+	var avgSpeed float64
+	if payload.Duration > 0 {
+		avgSpeed = payload.DistanceKm / payload.Duration.Hours()
+	}
+
+	reqURL := strings.NewReplacer(
+		"{start_ts}", url.QueryEscape(payload.StartAt.Format(time.RFC3339)),
+		"{duration_min}", url.QueryEscape(fmt.Sprintf("%.2f", payload.Duration.Minutes())),
+		"{elapsed_min}", url.QueryEscape(fmt.Sprintf("%.2f", payload.Elapsed.Minutes())),
+		"{steps}", url.QueryEscape(fmt.Sprintf("%d", payload.Steps)),
+		"{distance_km}", url.QueryEscape(fmt.Sprintf("%.2f", payload.DistanceKm)),
+		"{distance_mi}", url.QueryEscape(fmt.Sprintf("%.2f", kmToMiles(payload.DistanceKm))),
+		"{avg_speed_kmh}", url.QueryEscape(fmt.Sprintf("%.2f", avgSpeed)),
+		"{avg_speed_mph}", url.QueryEscape(fmt.Sprintf("%.2f", kmToMiles(avgSpeed))),
+		"{calories}", url.QueryEscape(fmt.Sprintf("%.0f", payload.Calories)),
+		"{target_speed}", url.QueryEscape(fmt.Sprintf("%.1f", payload.TargetSpeed)),
+		"{speed}", url.QueryEscape(fmt.Sprintf("%.1f", payload.Speed)),
+		"{avg_hr}", url.QueryEscape(fmt.Sprintf("%.0f", payload.AvgHR)),
+		"{max_hr}", url.QueryEscape(fmt.Sprintf("%d", payload.MaxHR)),
+	).Replace(target.URL)
+
+	method := http.MethodGet
+	if strings.EqualFold(target.Method, http.MethodPost) {
+		method = http.MethodPost
+	}
+
+	var statusCode int
+	defer func() {
+		var errStr string
+		if err != nil {
+			errStr = err.Error()
+		}
+
+		line := webhookLogLine{
+			Timestamp:   time.Now(),
+			URL:         reqURL,
+			Method:      method,
+			Headers:     slices.Sorted(maps.Keys(target.Headers)),
+			Attempt:     attempt,
+			Status:      statusCode,
+			Err:         errStr,
+			StartAt:     payload.StartAt,
+			DurationMin: payload.Duration.Minutes(),
+			Steps:       payload.Steps,
+			DistanceKm:  payload.DistanceKm,
+		}
+		logErr := logWebhook(line)
+		if logErr != nil {
+			slog.Error("logWebhook", "err", logErr)
+		}
+	}()
+
+	slog.Info("send webhook", "url", reqURL, "method", method, "attempt", attempt)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var body io.Reader
+	if method == http.MethodPost {
+		data, err := json.Marshal(webhookBody{
+			StartAt:     payload.StartAt,
+			DurationMin: payload.Duration.Minutes(),
+			ElapsedMin:  payload.Elapsed.Minutes(),
+			Steps:       payload.Steps,
+			DistanceKm:  payload.DistanceKm,
+			Calories:    payload.Calories,
+			TargetSpeed: payload.TargetSpeed,
+			Speed:       payload.Speed,
+			AvgHR:       payload.AvgHR,
+			MaxHR:       payload.MaxHR,
+
+			SpeedBreakdown: payload.SpeedBreakdown,
+		})
+		if err != nil {
+			return fmt.Errorf("marshal body: %w", err)
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, reqURL, body)
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for key, value := range target.Headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("send request: %w", err)
+	}
+	statusCode = resp.StatusCode
+
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+type webhookLogLine struct {
+	Timestamp   time.Time `json:"timestamp"`
+	URL         string    `json:"url"`
+	Method      string    `json:"method"`
+	Headers     []string  `json:"headers,omitempty"` // only names, as values might contain secrets
+	Attempt     int       `json:"attempt"`
+	Status      int       `json:"status"`
+	Err         string    `json:"err,omitempty"`
+	StartAt     time.Time `json:"start_ts"`
+	DurationMin float64   `json:"duration_min"`
+	Steps       int       `json:"steps"`
+	DistanceKm  float64   `json:"distance_km"`
+}
+
+func webhookLogPath() (string, error) {
+	return configSiblingPath("_webhooks.jsonl")
+}
+
+func logWebhook(line webhookLogLine) error {
+	logPath, err := webhookLogPath()
+	if err != nil {
+		return err
+	}
+	return appendJSONL(logPath, line)
+}
+
+// WebhookURLs is a list of webhook URLs. It is decoded from either a single URL or a list of URLs.
+type WebhookURLs []string
+
+func (urls *WebhookURLs) UnmarshalJSON(data []byte) error {
+	var single *string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*urls = nil
+		if single != nil {
+			*urls = WebhookURLs{*single}
+		}
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("webhook urls must be a string or a list of strings: %w", err)
+	}
+	*urls = list
+	return nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDeliverWebhookStatus(t *testing.T) {
+	configFile = filepath.Join(t.TempDir(), "walkingpad.json")
+
+	tests := []struct {
+		status  int
+		wantErr bool
+	}{
+		{status: http.StatusOK},
+		{status: http.StatusCreated},
+		{status: http.StatusAccepted},
+		{status: http.StatusNoContent},
+		{status: http.StatusBadRequest, wantErr: true},
+		{status: http.StatusNotFound, wantErr: true},
+		{status: http.StatusInternalServerError, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.status), func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+
+			err := deliverWebhook(webhookTarget{URL: server.URL}, webhookPayload{}, 1)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("expected error: %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestDeliverSessionsLimitsPending(t *testing.T) {
+	configFile = filepath.Join(t.TempDir(), "walkingpad.json")
+
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	const sessions = maxPendingDeliveries + 5
+	app := &App{WebhookURLs: []string{server.URL}, WebhookMaxAttempts: 1}
+	app.deliveries = make(chan *sessionDelivery, sessions+1)
+	start := time.Now()
+	for i := 0; i < sessions; i++ {
+		delivery := app.newSessionDelivery()
+		delivery.payload.StartAt = start.Add(time.Duration(i) * time.Minute)
+		app.queueDelivery(&delivery)
+	}
+	app.queueDelivery(nil)
+	close(app.deliveries)
+	app.deliverSessions()
+
+	// every session is sent once, and all pending ones are retried before it and with the final retry
+	var want, pending int32
+	for i := 0; i < sessions; i++ {
+		want += pending + 1
+		pending = min(pending+1, maxPendingDeliveries)
+	}
+	want += pending
+	if got := requests.Load(); got != want {
+		t.Errorf("requests = %d, want %d", got, want)
+	}
+}
+
+func TestQueueDeliveryDoesNotBlock(t *testing.T) {
+	app := &App{deliveries: make(chan *sessionDelivery, 1)}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 3; i++ {
+			app.queueDelivery(&sessionDelivery{})
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("queueDelivery blocked on a full queue")
+	}
+	if len(app.deliveries) != 1 {
+		t.Errorf("queued deliveries = %d, want 1", len(app.deliveries))
+	}
+}
+
+func TestRetryDeliveriesDropsOldSessions(t *testing.T) {
+	configFile = filepath.Join(t.TempDir(), "walkingpad.json")
+
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	target := webhookTarget{URL: server.URL}
+	app := &App{WebhookMaxAttempts: 1}
+	pending := app.retryDeliveries([]sessionDelivery{
+		{payload: webhookPayload{StartAt: time.Now().Add(-maxDeliveryAge - time.Minute)}, webhooks: []webhookTarget{target}},
+		{payload: webhookPayload{StartAt: time.Now().Add(-time.Hour)}, webhooks: []webhookTarget{target}},
+	})
+
+	if len(pending) != 1 || time.Since(pending[0].payload.StartAt) > maxDeliveryAge {
+		t.Errorf("pending = %+v, want the recent session only", pending)
+	}
+	if got := requests.Load(); got != 1 {
+		t.Errorf("requests = %d, want 1", got)
+	}
+}